@@ -0,0 +1,42 @@
+-- ScoreRecords和RoomPlayer的room_id从房间号改为Room.id的一次性迁移（PostgreSQL）
+--
+-- 执行顺序：停止服务 -> 执行本脚本 -> 启动新版本服务。
+-- 新版本启动时会删除redis中以房间号为key的旧缓存（见src/model/room/legacy.go）。
+--
+-- 房间号每周复用，同一个房间号对应多个房间：
+--   ScoreRecords按记录的创建时间，归属到创建时间不晚于该记录的最近一个同号房间；
+--   RoomPlayer以(房间号, 用户)唯一，复用房间号时会覆盖之前的记录，按最后更新时间归属。
+-- 先写成负数再取反，避免新的id与尚未迁移的房间号冲突触发唯一约束。
+-- 找不到对应房间的记录保持不变，执行结束时列出数量，需要人工处理。
+
+BEGIN;
+
+-- 已经迁移过时建表失败，整个事务回滚
+CREATE TABLE "_RoomIdMigration" ("migrated_time" TIMESTAMP NOT NULL DEFAULT now());
+INSERT INTO "_RoomIdMigration" DEFAULT VALUES;
+
+UPDATE "ScoreRecords" AS s SET "room_id" = -m."id"
+FROM (
+	SELECT DISTINCT ON (s2."id") s2."id" AS "record_id", r."id"
+	FROM "ScoreRecords" s2 JOIN "Room" r ON r."room_id" = s2."room_id" AND r."created_time" <= s2."created_time"
+	ORDER BY s2."id", r."created_time" DESC, r."id" DESC
+) AS m
+WHERE s."id" = m."record_id";
+
+UPDATE "RoomPlayer" AS p SET "room_id" = -m."id"
+FROM (
+	SELECT DISTINCT ON (p2."id") p2."id" AS "player_id", r."id"
+	FROM "RoomPlayer" p2 JOIN "Room" r ON r."room_id" = p2."room_id" AND r."created_time" <= p2."updated_time"
+	ORDER BY p2."id", r."created_time" DESC, r."id" DESC
+) AS m
+WHERE p."id" = m."player_id";
+
+UPDATE "ScoreRecords" SET "room_id" = -"room_id" WHERE "room_id" < 0;
+UPDATE "RoomPlayer" SET "room_id" = -"room_id" WHERE "room_id" < 0;
+
+COMMIT;
+
+-- room_id不是任何Room.id的记录数量，正常应当为0
+SELECT 'ScoreRecords' AS "table", count(*) AS "unmapped" FROM "ScoreRecords" WHERE "room_id" NOT IN (SELECT "id" FROM "Room")
+UNION ALL
+SELECT 'RoomPlayer', count(*) FROM "RoomPlayer" WHERE "room_id" NOT IN (SELECT "id" FROM "Room");
@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jianshao/poker_counter/src/model/room"
+	"github.com/jianshao/poker_counter/src/utils"
+)
+
+type adminRequestParams struct {
+	RoomId int `json:"room_id,omitempty"`
+}
+
+// 管理接口需要在header中携带ADMIN_TOKEN，未配置时拒绝所有请求
+func adminAuth(c *gin.Context) {
+	token := os.Getenv("ADMIN_TOKEN")
+	header := c.GetHeader("X-Admin-Token")
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(header)) != 1 {
+		utils.BuildResponse(c, http.StatusForbidden, nil, 1, "permission denied")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// 从database重建房间数据，room_id为0时重建所有未关闭的房间
+func recoverCtrl(c *gin.Context) {
+	var params adminRequestParams
+	if err := c.BindJSON(&params); err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	var err error
+	if params.RoomId == 0 {
+		err = room.Recover()
+	} else {
+		err = room.RecoverRoom(params.RoomId)
+	}
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, nil)
+	}
+}
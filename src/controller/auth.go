@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/utils"
+)
+
+const (
+	CTX_USER_ID = "user_id"
+)
+
+// 登录凭证放在header的Authorization: Bearer <token>中，
+// 无法设置header的场景（如websocket）可以放在query参数token中
+func getRequestToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return c.Query("token")
+}
+
+// 校验登录凭证，并将当前用户保存到上下文中
+func authRequired(c *gin.Context) {
+	userId, err := user.Authenticate(getRequestToken(c))
+	if err != nil {
+		utils.BuildResponse(c, http.StatusUnauthorized, nil, 1, "unauthorized")
+		c.Abort()
+		return
+	}
+	c.Set(CTX_USER_ID, userId)
+	c.Next()
+}
+
+// 获取当前登录的用户，只能在authRequired之后使用
+func getAuthUserId(c *gin.Context) int {
+	return c.GetInt(CTX_USER_ID)
+}
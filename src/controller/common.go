@@ -1,16 +1,72 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/jianshao/poker_counter/src/model/audit"
 	"github.com/jianshao/poker_counter/src/model/records"
 	"github.com/jianshao/poker_counter/src/model/room"
+	"github.com/jianshao/poker_counter/src/model/settlement"
 	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/utils"
+)
+
+// 需要客户端区别处理的错误使用单独的错误码，其他错误统一为2
+const (
+	CODE_FAILED          = 2
+	CODE_APPLY_PROCESSED = 3
+	CODE_RECORD_REVERSED = 4
+	// 买入和结算积分不符合房间限制
+	CODE_INVALID_BUYIN        = 5
+	CODE_NEGATIVE_CASHOUT     = 6
+	CODE_BUYIN_TOO_SMALL      = 7
+	CODE_BUYIN_TOO_LARGE      = 8
+	CODE_BUYIN_TOTAL_EXCEEDED = 9
+	CODE_REBUY_LIMIT          = 10
+	CODE_REBUY_COOLDOWN       = 11
+	// 双向确认结算
+	CODE_NEED_PLAYER_ACK    = 12
+	CODE_DISPUTE_UNRESOLVED = 13
+	CODE_PROXY_CASHOUT      = 14
+)
+
+var (
+	errorCodes = map[error]int{
+		records.ErrApplyProcessed:  CODE_APPLY_PROCESSED,
+		records.ErrRecordReversed:  CODE_RECORD_REVERSED,
+		records.ErrInvalidBuyIn:    CODE_INVALID_BUYIN,
+		records.ErrNegativeCashOut: CODE_NEGATIVE_CASHOUT,
+		room.ErrBuyInTooSmall:      CODE_BUYIN_TOO_SMALL,
+		room.ErrBuyInTooLarge:      CODE_BUYIN_TOO_LARGE,
+		room.ErrBuyInTotalExceeded: CODE_BUYIN_TOTAL_EXCEEDED,
+		room.ErrRebuyLimit:         CODE_REBUY_LIMIT,
+		room.ErrRebuyCooldown:      CODE_REBUY_COOLDOWN,
+		room.ErrNeedPlayerAck:      CODE_NEED_PLAYER_ACK,
+		room.ErrDisputeUnresolved:  CODE_DISPUTE_UNRESOLVED,
+		room.ErrProxyCashOutLocked: CODE_PROXY_CASHOUT,
+	}
 )
 
 func Init(r *gin.Engine) {
 	buildRouters(r)
 }
 
+func getErrorCode(err error) int {
+	for target, code := range errorCodes {
+		if errors.Is(err, target) {
+			return code
+		}
+	}
+	return CODE_FAILED
+}
+
+func buildErrorResponse(c *gin.Context, err error) {
+	utils.BuildResponse(c, http.StatusOK, nil, getErrorCode(err), err.Error())
+}
+
 type ApplyScoreResp struct {
 	Id          int    `json:"id"`
 	PlayerId    int    `json:"player_id"`
@@ -21,6 +77,59 @@ type ApplyScoreResp struct {
 	ApplyType   int    `json:"apply_type"`
 	ApplyTime   string `json:"apply_time"`
 	ConfirmTime string `json:"confirm_time"`
+	Money       string `json:"money"`
+	Currency    string `json:"currency"`
+	RefId       int    `json:"ref_id"`
+	Reason      string `json:"reason"`
+	CreatedBy   int    `json:"created_by"`
+	Proxy       bool   `json:"proxy"`
+	Auto        bool   `json:"auto"`
+}
+
+type BatchConfirmItemResp struct {
+	ApplyId int             `json:"apply_id"`
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Apply   *ApplyScoreResp `json:"apply,omitempty"`
+}
+
+type BatchConfirmResp struct {
+	Results   []BatchConfirmItemResp `json:"results"`
+	Succeeded int                    `json:"succeeded"`
+	Failed    int                    `json:"failed"`
+}
+
+type CommentResp struct {
+	Id         int    `json:"id"`
+	ApplyId    int    `json:"apply_id"`
+	UserId     int    `json:"user_id"`
+	Name       string `json:"name"`
+	Content    string `json:"content"`
+	CreateTime string `json:"create_time"`
+}
+
+type CommentListResp struct {
+	Comments []CommentResp `json:"comments"`
+	Count    int           `json:"count"`
+}
+
+type AuditEventResp struct {
+	Id         int             `json:"id"`
+	RoomId     int             `json:"room_id"`
+	Actor      int             `json:"actor"`
+	ActorName  string          `json:"actor_name"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetId   int             `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	CreateTime string          `json:"create_time"`
+}
+
+type AuditEventListResp struct {
+	Events []AuditEventResp `json:"events"`
+	Page   int              `json:"page"`
+	Count  int              `json:"count"`
 }
 
 type ApplyScoreListResp struct {
@@ -33,23 +142,105 @@ type PlayerInfoResp struct {
 	Name       string           `json:"name"`
 	OpenId     string           `json:"open_id"`
 	Status     int              `json:"status"`
+	Role       int              `json:"role"`
 	CurrRoomId int              `json:"curr_room_id"`
 	CurrScore  int              `json:"curr_score"`
 	FinalScore int              `json:"final_score"`
+	CurrMoney  string           `json:"curr_money"`
+	FinalMoney string           `json:"final_money"`
+	Currency   string           `json:"currency"`
 	JoinTime   string           `json:"join_time"`
 	ExitTime   string           `json:"exit_time"`
 	Applies    []ApplyScoreResp `json:"applies"`
 }
 
 type RoomInfoResp struct {
-	Id        int              `json:"room_id"`
-	Owner     int              `json:"owner"`
-	Status    int              `json:"status"`
-	StartTime string           `json:"start_time"`
-	Players   []PlayerInfoResp `json:"players"`
+	Id           int              `json:"room_id"`
+	Code         int              `json:"code"`
+	Owner        int              `json:"owner"`
+	Status       int              `json:"status"`
+	StartTime    string           `json:"start_time"`
+	Players      []PlayerInfoResp `json:"players"`
+	Rate         ChipRateResp     `json:"rate"`
+	AutoApprove  AutoApproveResp  `json:"auto_approve"`
+	BuyIn        BuyInPolicyResp  `json:"buy_in"`
+	ApplyTimeout int              `json:"apply_timeout"`
+	TwoSided     bool             `json:"two_sided_cashout"`
+	Version      int64            `json:"version"`
+}
+
+// 非房间成员只能看到的房间信息
+type RoomBriefResp struct {
+	Id     int `json:"room_id"`
+	Code   int `json:"code"`
+	Owner  int `json:"owner"`
+	Status int `json:"status"`
+}
+
+type BuyInPolicyResp struct {
+	MinBuyIn      int `json:"min_buy_in"`
+	MaxBuyIn      int `json:"max_buy_in"`
+	MaxTotalBuyIn int `json:"max_total_buy_in"`
+	MaxRebuys     int `json:"max_rebuys"`
+	RebuyCooldown int `json:"rebuy_cooldown"`
+}
+
+type AutoApproveResp struct {
+	Enabled   bool  `json:"enabled"`
+	MaxChips  int   `json:"max_chips"`
+	MaxRebuys int   `json:"max_rebuys"`
+	Roles     []int `json:"roles"`
+	UserIds   []int `json:"user_ids"`
+}
+
+type ChipRateResp struct {
+	Chips    int    `json:"chips"`
+	Money    string `json:"money"`
+	Currency string `json:"currency"`
+	Scale    int32  `json:"scale"`
+	Rounding int    `json:"rounding"`
+}
+
+type SettlementPlayerResp struct {
+	Id           int    `json:"id"`
+	Name         string `json:"name"`
+	BuyIn        int    `json:"buy_in"`
+	CashOut      int    `json:"cash_out"`
+	Net          int    `json:"net"`
+	BuyInMoney   string `json:"buy_in_money"`
+	CashOutMoney string `json:"cash_out_money"`
+	NetMoney     string `json:"net_money"`
+}
+
+type TransferResp struct {
+	From        int    `json:"from"`
+	FromName    string `json:"from_name"`
+	To          int    `json:"to"`
+	ToName      string `json:"to_name"`
+	Amount      int    `json:"amount"`
+	AmountMoney string `json:"amount_money"`
+}
+
+type SettlementResp struct {
+	RoomId    int                    `json:"room_id"`
+	Currency  string                 `json:"currency"`
+	Players   []SettlementPlayerResp `json:"players"`
+	Transfers []TransferResp         `json:"transfers"`
+}
+
+type BalanceResp struct {
+	RoomId   int  `json:"room_id"`
+	BuyIn    int  `json:"buy_in"`
+	CashOut  int  `json:"cash_out"`
+	Adjust   int  `json:"adjust"`
+	Manual   int  `json:"manual"`
+	House    int  `json:"house"`
+	Diff     int  `json:"diff"`
+	Balanced bool `json:"balanced"`
 }
 
 func buildApplyScoreResp(applyScore *records.ApplyScore) ApplyScoreResp {
+	rate := room.GetChipRate(applyScore.RoomId)
 	return ApplyScoreResp{
 		Id:          applyScore.Id,
 		PlayerId:    applyScore.UserId,
@@ -60,9 +251,40 @@ func buildApplyScoreResp(applyScore *records.ApplyScore) ApplyScoreResp {
 		ApplyType:   applyScore.ApplyType,
 		ApplyTime:   applyScore.ApplyTime,
 		ConfirmTime: applyScore.ConfirmTime,
+		Money:       rate.Format(applyScore.Score),
+		Currency:    rate.Currency,
+		RefId:       applyScore.RefId,
+		Reason:      applyScore.Reason,
+		CreatedBy:   applyScore.CreatedBy,
+		Proxy:       applyScore.CreatedBy != 0,
+		Auto:        applyScore.Auto,
 	}
 }
 
+// 每个申请的结果使用与单个审批相同的错误码
+func buildBatchConfirmResp(results []room.ConfirmResult) BatchConfirmResp {
+	resp := BatchConfirmResp{
+		Results: []BatchConfirmItemResp{},
+	}
+	for _, result := range results {
+		item := BatchConfirmItemResp{
+			ApplyId: result.ApplyId,
+			Message: "success",
+		}
+		if result.Err != nil {
+			item.Code = getErrorCode(result.Err)
+			item.Message = result.Err.Error()
+			resp.Failed += 1
+		} else {
+			apply := buildApplyScoreResp(result.Apply)
+			item.Apply = &apply
+			resp.Succeeded += 1
+		}
+		resp.Results = append(resp.Results, item)
+	}
+	return resp
+}
+
 func buildPlayerInfoResp(userInfo *user.PlayerInfo, roomId int) PlayerInfoResp {
 	// 只需要用户本身的静态数据
 	if roomId == 0 {
@@ -73,6 +295,7 @@ func buildPlayerInfoResp(userInfo *user.PlayerInfo, roomId int) PlayerInfoResp {
 			CurrRoomId: userInfo.CurrRoomId,
 		}
 	}
+	rate := room.GetChipRate(roomId)
 	room, ok := userInfo.Rooms[roomId]
 	if !ok {
 		room = &user.UserRoomInfo{
@@ -90,8 +313,12 @@ func buildPlayerInfoResp(userInfo *user.PlayerInfo, roomId int) PlayerInfoResp {
 		OpenId:     userInfo.OpenId,
 		CurrRoomId: userInfo.CurrRoomId,
 		Status:     room.Status,
+		Role:       room.Role,
 		CurrScore:  room.CurrScore,
 		FinalScore: room.FinalScore,
+		CurrMoney:  rate.Format(room.CurrScore),
+		FinalMoney: rate.Format(room.FinalScore),
+		Currency:   rate.Currency,
 		JoinTime:   room.JoinTime,
 		ExitTime:   room.ExitTime,
 		Applies:    applies,
@@ -101,13 +328,115 @@ func buildPlayerInfoResp(userInfo *user.PlayerInfo, roomId int) PlayerInfoResp {
 func buildRoomInfoResp(roomInfo *room.RoomInfo) RoomInfoResp {
 	players := []PlayerInfoResp{}
 	for _, playerId := range roomInfo.Players {
-		players = append(players, buildPlayerInfoResp(user.GetUser(playerId), roomInfo.RoomId))
+		player := buildPlayerInfoResp(user.GetUser(playerId), roomInfo.RoomId)
+		if playerId == roomInfo.Owner {
+			player.Role = user.USER_ROLE_OWNER
+		}
+		players = append(players, player)
 	}
 	return RoomInfoResp{
-		Id:      roomInfo.RoomId,
-		Owner:   roomInfo.Owner,
-		Status:  roomInfo.Status,
-		Players: players,
+		Id:          roomInfo.RoomId,
+		Code:        roomInfo.Code,
+		Owner:       roomInfo.Owner,
+		Status:      roomInfo.Status,
+		Players:     players,
+		Rate:        buildChipRateResp(room.GetChipRate(roomInfo.RoomId)),
+		AutoApprove: buildAutoApproveResp(&roomInfo.AutoApprove),
+		BuyIn: BuyInPolicyResp{
+			MinBuyIn:      roomInfo.BuyIn.MinBuyIn,
+			MaxBuyIn:      roomInfo.BuyIn.MaxBuyIn,
+			MaxTotalBuyIn: roomInfo.BuyIn.MaxTotalBuyIn,
+			MaxRebuys:     roomInfo.BuyIn.MaxRebuys,
+			RebuyCooldown: roomInfo.BuyIn.RebuyCooldown,
+		},
+		ApplyTimeout: roomInfo.ApplyTimeout,
+		TwoSided:     roomInfo.TwoSidedCashOut,
+		Version:      roomInfo.Version,
+	}
+}
+
+func buildRoomBriefResp(roomInfo *room.RoomInfo) RoomBriefResp {
+	return RoomBriefResp{
+		Id:     roomInfo.RoomId,
+		Code:   roomInfo.Code,
+		Owner:  roomInfo.Owner,
+		Status: roomInfo.Status,
+	}
+}
+
+func buildAutoApproveResp(rule *room.AutoApproveRule) AutoApproveResp {
+	resp := AutoApproveResp{
+		Enabled:   rule.Enabled,
+		MaxChips:  rule.MaxChips,
+		MaxRebuys: rule.MaxRebuys,
+		Roles:     []int{},
+		UserIds:   []int{},
+	}
+	resp.Roles = append(resp.Roles, rule.Roles...)
+	resp.UserIds = append(resp.UserIds, rule.UserIds...)
+	return resp
+}
+
+func buildChipRateResp(rate *room.ChipRate) ChipRateResp {
+	return ChipRateResp{
+		Chips:    rate.Chips,
+		Money:    rate.Money.String(),
+		Currency: rate.Currency,
+		Scale:    rate.Scale,
+		Rounding: rate.Rounding,
+	}
+}
+
+func buildCommentResp(comment *records.Comment) CommentResp {
+	resp := CommentResp{
+		Id:         comment.Id,
+		ApplyId:    comment.ApplyId,
+		UserId:     comment.UserId,
+		Content:    comment.Content,
+		CreateTime: comment.CreateTime,
+	}
+	if commenter := user.GetUser(comment.UserId); commenter != nil {
+		resp.Name = commenter.Name
+	}
+	return resp
+}
+
+func buildCommentListResp(comments []records.Comment) CommentListResp {
+	commentsResp := []CommentResp{}
+	for _, comment := range comments {
+		commentsResp = append(commentsResp, buildCommentResp(&comment))
+	}
+	return CommentListResp{
+		Comments: commentsResp,
+		Count:    len(commentsResp),
+	}
+}
+
+func buildAuditEventListResp(events []audit.Event, page int) AuditEventListResp {
+	eventsResp := []AuditEventResp{}
+	for _, event := range events {
+		resp := AuditEventResp{
+			Id:         event.Id,
+			RoomId:     event.RoomId,
+			Actor:      event.Actor,
+			Action:     event.Action,
+			TargetType: event.TargetType,
+			TargetId:   event.TargetId,
+			Before:     event.Before,
+			After:      event.After,
+			CreateTime: event.CreateTime,
+		}
+		if event.Actor != audit.ACTOR_SYSTEM {
+			if actor := user.GetUser(event.Actor); actor != nil {
+				resp.ActorName = actor.Name
+			}
+		}
+		eventsResp = append(eventsResp, resp)
+	}
+	return AuditEventListResp{
+		Events: eventsResp,
+		Page:   page,
+		Count:  len(eventsResp),
 	}
 }
 
@@ -121,3 +450,53 @@ func buildApplyListResp(applyList []records.ApplyScore) ApplyScoreListResp {
 		Count:     len(applyListResp),
 	}
 }
+
+func buildSettlementResp(result *settlement.Settlement) *SettlementResp {
+	if result == nil {
+		return nil
+	}
+	rate := room.GetChipRate(result.RoomId)
+	players := []SettlementPlayerResp{}
+	for _, player := range result.Players {
+		players = append(players, SettlementPlayerResp{
+			Id:           player.UserId,
+			Name:         player.Name,
+			BuyIn:        player.BuyIn,
+			CashOut:      player.CashOut,
+			Net:          player.Net,
+			BuyInMoney:   rate.Format(player.BuyIn),
+			CashOutMoney: rate.Format(player.CashOut),
+			NetMoney:     rate.Format(player.Net),
+		})
+	}
+	transfers := []TransferResp{}
+	for _, transfer := range result.Transfers {
+		transfers = append(transfers, TransferResp{
+			From:        transfer.From,
+			FromName:    transfer.FromName,
+			To:          transfer.To,
+			ToName:      transfer.ToName,
+			Amount:      transfer.Amount,
+			AmountMoney: rate.Format(transfer.Amount),
+		})
+	}
+	return &SettlementResp{
+		RoomId:    result.RoomId,
+		Currency:  rate.Currency,
+		Players:   players,
+		Transfers: transfers,
+	}
+}
+
+func buildBalanceResp(balance *records.Balance) BalanceResp {
+	return BalanceResp{
+		RoomId:   balance.RoomId,
+		BuyIn:    balance.BuyIn,
+		CashOut:  balance.CashOut,
+		Adjust:   balance.Adjust,
+		Manual:   balance.Manual,
+		House:    balance.House,
+		Diff:     balance.Diff,
+		Balanced: balance.Diff == 0,
+	}
+}
@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/jianshao/poker_counter/src/model/event"
+	"github.com/jianshao/poker_counter/src/model/room"
+	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/utils"
+	"github.com/jianshao/poker_counter/src/utils/logs"
+)
+
+const (
+	WS_WRITE_TIMEOUT = 10 * time.Second
+	WS_PONG_TIMEOUT  = 60 * time.Second
+	WS_PING_INTERVAL = 50 * time.Second
+
+	// 定时发送注释行，避免代理因为连接空闲而断开
+	SSE_KEEPALIVE_INTERVAL = 25 * time.Second
+	// 客户端需要丢弃本地状态，重新获取房间信息
+	SSE_EVENT_RESET = "reset"
+)
+
+var (
+	// 小程序的请求没有固定的Origin，不做校验
+	upgrader = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+)
+
+type EventResp struct {
+	Id      int64           `json:"id"`
+	Type    string          `json:"type"`
+	RoomId  int             `json:"room_id"`
+	Version int64           `json:"version"`
+	UserId  int             `json:"user_id"`
+	Player  *PlayerInfoResp `json:"player,omitempty"`
+	Apply   *ApplyScoreResp `json:"apply,omitempty"`
+}
+
+// 申请相关的事件携带申请内容，其他事件携带玩家的最新状态
+func buildEventResp(e *event.Event) EventResp {
+	resp := EventResp{
+		Id:      e.Id,
+		Type:    e.Type,
+		RoomId:  e.RoomId,
+		Version: e.Version,
+		UserId:  e.UserId,
+	}
+	if e.Apply != nil {
+		apply := buildApplyScoreResp(e.Apply)
+		resp.Apply = &apply
+	}
+	if e.Type != event.EVENT_CLOSE_ROOM {
+		if userInfo := user.GetUser(e.UserId); userInfo != nil {
+			player := buildPlayerInfoResp(userInfo, e.RoomId)
+			resp.Player = &player
+		}
+	}
+	return resp
+}
+
+// 获取要订阅的房间，只有房间成员可以订阅
+func getSubscribeRoomId(c *gin.Context) (int, bool) {
+	roomId, err := strconv.Atoi(c.DefaultQuery("room_id", ""))
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "room id error")
+		return 0, false
+	}
+	if !room.IsMember(roomId, getAuthUserId(c)) {
+		utils.BuildResponse(c, http.StatusForbidden, nil, 2, room.ErrPermissionDenied.Error())
+		return 0, false
+	}
+	return roomId, true
+}
+
+// 通过websocket推送房间事件
+func roomWebsocketCtrl(c *gin.Context) {
+	roomId, ok := getSubscribeRoomId(c)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logs.Error(c, "websocket upgrade failed: "+err.Error())
+		return
+	}
+	defer conn.Close()
+
+	sub := event.Subscribe(roomId)
+	defer event.Unsubscribe(sub)
+
+	// 客户端只需要响应ping，读协程用于感知连接断开
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadDeadline(time.Now().Add(WS_PONG_TIMEOUT))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(WS_PONG_TIMEOUT))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(WS_PING_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case e, ok := <-sub.C:
+			conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+			if !ok {
+				// 推送不及时被断开，客户端需要重新连接
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer"))
+				return
+			}
+			if err := conn.WriteJSON(buildEventResp(e)); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// 断线重连时客户端通过Last-Event-ID带上收到的最后一个事件，
+// 无法设置header的场景可以放在query参数last_event_id中
+func getLastEventId(c *gin.Context) int64 {
+	lastId := c.GetHeader("Last-Event-ID")
+	if lastId == "" {
+		lastId = c.Query("last_event_id")
+	}
+	id, err := strconv.ParseInt(lastId, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+func renderSSEvent(c *gin.Context, e *event.Event) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.FormatInt(e.Id, 10),
+		Event: e.Type,
+		Data:  buildEventResp(e),
+	})
+}
+
+// 通过Server-Sent Events推送房间事件，用于不支持websocket的客户端
+func roomEventsCtrl(c *gin.Context) {
+	roomId, ok := getSubscribeRoomId(c)
+	if !ok {
+		return
+	}
+
+	sub, history, ok := event.SubscribeFrom(roomId, getLastEventId(c))
+	defer event.Unsubscribe(sub)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// 断开期间的事件已经无法补发，通知客户端重新获取房间信息
+	if !ok {
+		c.Render(-1, sse.Event{
+			Id:    strconv.FormatInt(event.LastId(roomId), 10),
+			Event: SSE_EVENT_RESET,
+			Data:  EventResp{Type: SSE_EVENT_RESET, RoomId: roomId},
+		})
+	}
+	// 补发和订阅在同一把锁内完成，事件不会重复也不会遗漏
+	for _, e := range history {
+		renderSSEvent(c, e)
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(SSE_KEEPALIVE_INTERVAL)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case e, ok := <-sub.C:
+			if !ok {
+				// 推送不及时被断开，客户端会带上Last-Event-ID重新连接
+				return false
+			}
+			renderSSEvent(c, e)
+			return true
+		case <-ticker.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jianshao/poker_counter/src/utils"
+)
+
+const (
+	IDEMPOTENCY_HEADER = "Idempotency-Key"
+	// 请求结果的保存时间，可以通过环境变量IDEMPOTENCY_WINDOW配置，单位为秒
+	DEFAULT_IDEMPOTENCY_WINDOW = 24 * 3600
+	MAX_IDEMPOTENCY_KEY_LENGTH = 128
+	// 处理中标记的保存时间，进程异常退出时标记会自动过期，单位为秒
+	IDEMPOTENCY_PENDING_TIMEOUT = 60
+)
+
+// 同一个key的请求结果，Done为false表示第一次请求还在处理中
+type idempotentResult struct {
+	Hash   string `json:"hash"`
+	Done   bool   `json:"done"`
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+// 记录返回给客户端的内容，以便重试时原样返回
+type responseRecorder struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func idempotencyWindow() int {
+	window, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_WINDOW"))
+	if err != nil || window <= 0 {
+		return DEFAULT_IDEMPOTENCY_WINDOW
+	}
+	return window
+}
+
+// key只在同一个用户的同一个接口内有效
+func buildIdempotencyKey(c *gin.Context, key string) string {
+	return fmt.Sprintf("Idempotency:%d:%s:%s", getAuthUserId(c), c.FullPath(), key)
+}
+
+// 读取请求内容计算摘要，并还原请求内容供后续处理
+func hashRequestBody(c *gin.Context) (string, error) {
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// 成功、参数错误以及单独错误码的业务校验失败，重试的结果不会改变，可以保存
+// 统一错误码2可能是数据库等临时错误，不保存，允许客户端使用相同的key重试
+func isDeterministicResult(body []byte) bool {
+	var resp utils.ApiResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false
+	}
+	if resp.Code == 0 || resp.Code == 1 {
+		return true
+	}
+	for _, code := range errorCodes {
+		if resp.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func saveIdempotentResult(key string, result *idempotentResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return utils.SetString(key, string(data), idempotencyWindow())
+}
+
+// 修改类的请求可以在header中携带Idempotency-Key，相同key的重试直接返回第一次请求的结果
+// 只能在authRequired之后使用
+func idempotencyRequired(c *gin.Context) {
+	key := c.GetHeader(IDEMPOTENCY_HEADER)
+	if key == "" || c.Request.Method == http.MethodGet {
+		c.Next()
+		return
+	}
+	if len(key) > MAX_IDEMPOTENCY_KEY_LENGTH {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "idempotency key too long")
+		c.Abort()
+		return
+	}
+
+	hash, err := hashRequestBody(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		c.Abort()
+		return
+	}
+
+	redisKey := buildIdempotencyKey(c, key)
+	pending, _ := json.Marshal(&idempotentResult{Hash: hash})
+	ok, err := utils.SetStringNX(redisKey, string(pending), IDEMPOTENCY_PENDING_TIMEOUT)
+	if err != nil {
+		// redis不可用时不阻塞请求
+		c.Next()
+		return
+	}
+	if !ok {
+		replayIdempotentResult(c, redisKey, hash)
+		return
+	}
+
+	// 处理中panic或结果没有保存时删除标记，允许客户端使用相同的key重试
+	saved := false
+	defer func() {
+		if !saved {
+			utils.Del(redisKey)
+		}
+	}()
+
+	recorder := &responseRecorder{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+	c.Writer = recorder
+	c.Next()
+
+	// 服务端错误和临时错误允许客户端使用相同的key重试
+	if recorder.Status() >= http.StatusInternalServerError || !isDeterministicResult(recorder.body.Bytes()) {
+		return
+	}
+	err = saveIdempotentResult(redisKey, &idempotentResult{
+		Hash:   hash,
+		Done:   true,
+		Status: recorder.Status(),
+		Body:   recorder.body.String(),
+	})
+	saved = err == nil
+}
+
+func replayIdempotentResult(c *gin.Context, redisKey, hash string) {
+	defer c.Abort()
+	data, err := utils.GetString(redisKey)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusConflict, nil, 1, "request is being processed")
+		return
+	}
+	var result idempotentResult
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		utils.BuildResponse(c, http.StatusConflict, nil, 1, err.Error())
+		return
+	}
+
+	if result.Hash != hash {
+		utils.BuildResponse(c, http.StatusUnprocessableEntity, nil, 1, "idempotency key reused with different request")
+		return
+	}
+	if !result.Done {
+		utils.BuildResponse(c, http.StatusConflict, nil, 1, "request is being processed")
+		return
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(result.Status, gin.MIMEJSON, []byte(result.Body))
+}
@@ -10,13 +10,17 @@ import (
 )
 
 type RecordsReq struct {
-	RoomId    int `json:"room_id,omitempty"`
-	Owner     int `json:"owner,omitempty"`
-	UserId    int `json:"user_id,omitempty"`
-	ApplyId   int `json:"apply_id,omitempty"`
-	Score     int `json:"score,omitempty"`
-	ApplyType int `json:"apply_type,omitempty"`
-	Status    int `json:"status,omitempty"`
+	RoomId    int    `json:"room_id,omitempty"`
+	UserId    int    `json:"user_id,omitempty"` // 操作的目标玩家，当前用户从登录凭证中获取
+	ApplyId   int    `json:"apply_id,omitempty"`
+	Score     int    `json:"score,omitempty"`
+	ApplyType int    `json:"apply_type,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	Mode      *int   `json:"mode,omitempty"` // 平账方式，0是有效值，不能省略
+	Reason    string `json:"reason,omitempty"`
+	ApplyIds  []int  `json:"apply_ids,omitempty"`
+	All       bool   `json:"all,omitempty"`     // 批量审批房间内所有待审批的申请
+	Content   string `json:"content,omitempty"` // 结算争议的留言
 }
 
 func buildRecordParams(c *gin.Context) (*RecordsReq, error) {
@@ -35,9 +39,9 @@ func applyBuyInCtrl(c *gin.Context) {
 		return
 	}
 
-	apply, err := room.ApplyBuyIn(params.RoomId, params.UserId, params.Score, params.ApplyType)
+	apply, err := room.ApplyBuyIn(params.RoomId, getAuthUserId(c), params.Score, params.ApplyType)
 	if err != nil {
-		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+		buildErrorResponse(c, err)
 	} else {
 		utils.BuildResponseOk(c, buildApplyScoreResp(apply))
 	}
@@ -51,13 +55,215 @@ func confirmBuyInCtrl(c *gin.Context) {
 		return
 	}
 
-	apply, err := room.ConfirmBuyIn(params.RoomId, params.Owner, params.ApplyId, params.Status)
+	apply, err := room.ConfirmBuyIn(params.RoomId, getAuthUserId(c), params.ApplyId, params.Status)
+	if err != nil {
+		buildErrorResponse(c, err)
+	} else {
+		utils.BuildResponseOk(c, buildApplyScoreResp(apply))
+	}
+
+}
+
+// user cancel own apply before it is confirmed
+func cancelApplyCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	apply, err := room.CancelApply(params.RoomId, getAuthUserId(c), params.ApplyId)
+	if err != nil {
+		buildErrorResponse(c, err)
+	} else {
+		utils.BuildResponseOk(c, buildApplyScoreResp(apply))
+	}
+}
+
+// user change score of own apply before it is confirmed
+func amendApplyCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	apply, err := room.AmendApply(params.RoomId, getAuthUserId(c), params.ApplyId, params.Score)
+	if err != nil {
+		buildErrorResponse(c, err)
+	} else {
+		utils.BuildResponseOk(c, buildApplyScoreResp(apply))
+	}
+}
+
+// owner reverse an accepted record by adding a compensating record
+func reverseRecordCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	reversal, err := room.ReverseRecord(params.RoomId, getAuthUserId(c), params.ApplyId)
+	if err != nil {
+		buildErrorResponse(c, err)
+	} else {
+		utils.BuildResponseOk(c, buildApplyScoreResp(reversal))
+	}
+}
+
+// owner, co-host or banker record a buy in or cash out for a player
+func proxyRecordCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	apply, err := room.ProxyRecord(params.RoomId, getAuthUserId(c), params.UserId, params.Score, params.ApplyType)
+	if err != nil {
+		buildErrorResponse(c, err)
+	} else {
+		utils.BuildResponseOk(c, buildApplyScoreResp(apply))
+	}
+}
+
+// owner or co-host adjust score of a player with a reason
+func adjustScoreCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	apply, err := room.AdjustScore(params.RoomId, getAuthUserId(c), params.UserId, params.Score, params.Reason)
+	if err != nil {
+		buildErrorResponse(c, err)
+	} else {
+		utils.BuildResponseOk(c, buildApplyScoreResp(apply))
+	}
+}
+
+// 房间内的所有记录，冲正记录通过ref_id关联原记录
+func getRoomHistoryCtrl(c *gin.Context) {
+	roomIdStr := c.DefaultQuery("room_id", "")
+	roomId, err := strconv.Atoi(roomIdStr)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 1, err.Error())
+		return
+	}
+
+	applies, err := room.GetRoomHistory(roomId, getAuthUserId(c))
 	if err != nil {
 		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildApplyListResp(applies))
+	}
+}
+
+// player acknowledge a cash-out entered by owner
+func acknowledgeCashOutCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	apply, err := room.AcknowledgeCashOut(params.RoomId, getAuthUserId(c), params.ApplyId)
+	if err != nil {
+		buildErrorResponse(c, err)
 	} else {
 		utils.BuildResponseOk(c, buildApplyScoreResp(apply))
 	}
+}
 
+// the other side of a cash-out disputes the score
+func disputeCashOutCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	apply, err := room.DisputeCashOut(params.RoomId, getAuthUserId(c), params.ApplyId, params.Content)
+	if err != nil {
+		buildErrorResponse(c, err)
+	} else {
+		utils.BuildResponseOk(c, buildApplyScoreResp(apply))
+	}
+}
+
+// owner resolve a disputed cash-out with the agreed score
+func resolveDisputeCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	apply, err := room.ResolveDispute(params.RoomId, getAuthUserId(c), params.ApplyId, params.Status, params.Score)
+	if err != nil {
+		buildErrorResponse(c, err)
+	} else {
+		utils.BuildResponseOk(c, buildApplyScoreResp(apply))
+	}
+}
+
+// add a comment to a record
+func addCommentCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	comment, err := room.AddComment(params.RoomId, getAuthUserId(c), params.ApplyId, params.Content)
+	if err != nil {
+		buildErrorResponse(c, err)
+	} else {
+		utils.BuildResponseOk(c, buildCommentResp(comment))
+	}
+}
+
+// 积分记录下的所有留言
+func getCommentsCtrl(c *gin.Context) {
+	roomId, err := strconv.Atoi(c.DefaultQuery("room_id", ""))
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 1, err.Error())
+		return
+	}
+	applyId, err := strconv.Atoi(c.DefaultQuery("apply_id", ""))
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 1, err.Error())
+		return
+	}
+
+	comments, err := room.GetComments(roomId, getAuthUserId(c), applyId)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildCommentListResp(comments))
+	}
+}
+
+// owner accept or reject a list of applies, or all pending applies of room
+func confirmBuyInBatchCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+	if !params.All && len(params.ApplyIds) == 0 {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "apply ids empty")
+		return
+	}
+
+	results, err := room.ConfirmBuyInBatch(params.RoomId, getAuthUserId(c), params.ApplyIds, params.All, params.Status)
+	if err != nil {
+		buildErrorResponse(c, err)
+	} else {
+		utils.BuildResponseOk(c, buildBatchConfirmResp(results))
+	}
 }
 
 func getApplyScoreAllCtrl(c *gin.Context) {
@@ -65,11 +271,50 @@ func getApplyScoreAllCtrl(c *gin.Context) {
 	roomId, err := strconv.Atoi(roomIdStr)
 	if err != nil {
 		utils.BuildResponse(c, http.StatusOK, nil, 1, err.Error())
+		return
+	}
+
+	applies, err := room.GetAllScoreApplies(roomId, getAuthUserId(c))
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildApplyListResp(applies))
+	}
+}
+
+// 关闭房间前检查买入和结算积分是否平衡
+func checkBalanceCtrl(c *gin.Context) {
+	roomIdStr := c.DefaultQuery("room_id", "")
+	roomId, err := strconv.Atoi(roomIdStr)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 1, err.Error())
+		return
 	}
 
-	applies, err := room.GetAllScoreApplies(roomId)
+	balance, err := room.CheckBalance(roomId, getAuthUserId(c))
 	if err != nil {
 		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildBalanceResp(balance))
+	}
+}
+
+// owner resolve score difference
+func resolveBalanceCtrl(c *gin.Context) {
+	params, err := buildRecordParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	if params.Mode == nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "mode required")
+		return
+	}
+
+	applies, err := room.ResolveBalance(params.RoomId, getAuthUserId(c), *params.Mode, params.UserId)
+	if err != nil {
+		buildErrorResponse(c, err)
 	} else {
 		utils.BuildResponseOk(c, buildApplyListResp(applies))
 	}
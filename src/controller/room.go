@@ -3,15 +3,43 @@ package controller
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jianshao/poker_counter/src/model/audit"
 	"github.com/jianshao/poker_counter/src/model/room"
 	"github.com/jianshao/poker_counter/src/utils"
+	"github.com/shopspring/decimal"
 )
 
 type roomRequestParams struct {
-	RoomId int `json:"room_id,omitempty"`
-	UserId int `json:"user_id,omitempty"`
+	RoomId   int    `json:"room_id,omitempty"`
+	UserId   int    `json:"user_id,omitempty"` // 操作的目标用户，当前用户从登录凭证中获取
+	Role     int    `json:"role,omitempty"`
+	Chips    int    `json:"chips,omitempty"`
+	Money    string `json:"money,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Scale    int32  `json:"scale,omitempty"`
+	Rounding int    `json:"rounding,omitempty"`
+
+	// 买入限制
+	MinBuyIn      int `json:"min_buy_in,omitempty"`
+	MaxBuyIn      int `json:"max_buy_in,omitempty"`
+	MaxTotalBuyIn int `json:"max_total_buy_in,omitempty"`
+	RebuyCooldown int `json:"rebuy_cooldown,omitempty"`
+
+	// 申请的超时时间，单位为秒
+	ApplyTimeout int `json:"apply_timeout,omitempty"`
+
+	// 双向确认结算
+	TwoSidedCashOut bool `json:"two_sided_cashout,omitempty"`
+
+	// 自动审批规则，补码次数同时用于买入限制
+	Enabled   bool  `json:"enabled,omitempty"`
+	MaxChips  int   `json:"max_chips,omitempty"`
+	MaxRebuys *int  `json:"max_rebuys,omitempty"` // 不传时不限制
+	Roles     []int `json:"roles,omitempty"`
+	UserIds   []int `json:"user_ids,omitempty"`
 }
 
 func buildRoomParams(c *gin.Context) (*roomRequestParams, error) {
@@ -24,33 +52,48 @@ func buildRoomParams(c *gin.Context) (*roomRequestParams, error) {
 
 // 1. owner create room
 func createRoomCtrl(c *gin.Context) {
-	params, err := buildRoomParams(c)
-	if err != nil {
-		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
-		return
-	}
-
-	if params.UserId == 0 {
+	userId := getAuthUserId(c)
+	if userId == 0 {
 		utils.BuildResponse(c, http.StatusBadRequest, nil, 2, "用户信息不正确")
 		return
 	}
 
-	roomInfo, err := room.CreateRoom(params.UserId)
+	roomInfo, err := room.CreateRoom(userId)
 	if err != nil {
-		utils.BuildResponse(c, http.StatusOK, nil, 3, err.Error())
+		buildErrorResponse(c, err)
 	} else {
 		utils.BuildResponseOk(c, buildRoomInfoResp(roomInfo))
 	}
 }
 
+// 通过room_id或房间号code查找房间，加入房间时使用code
+// 通过code查找时还不是房间成员，只返回房间的基本信息
 func checkRoomCtrl(c *gin.Context) {
+	userId := getAuthUserId(c)
+	if codeStr := c.DefaultQuery("code", ""); codeStr != "" {
+		code, err := strconv.Atoi(codeStr)
+		if err != nil {
+			utils.BuildResponse(c, http.StatusOK, nil, 1, "room code error")
+			return
+		}
+		roomInfo := room.CheckRoomByCode(code)
+		if roomInfo == nil {
+			utils.BuildResponse(c, http.StatusOK, nil, 2, "room not exist")
+		} else if room.IsMember(roomInfo.RoomId, userId) {
+			utils.BuildResponseOk(c, buildRoomInfoResp(roomInfo))
+		} else {
+			utils.BuildResponseOk(c, buildRoomBriefResp(roomInfo))
+		}
+		return
+	}
+
 	roomIdStr := c.DefaultQuery("room_id", "")
 	if roomId, err := strconv.Atoi(roomIdStr); err == nil {
-		roomInfo := room.CheckRoom(roomId)
-		if roomInfo != nil {
+		roomInfo, err := room.GetRoomInfo(roomId, userId)
+		if err == nil {
 			utils.BuildResponseOk(c, buildRoomInfoResp(roomInfo))
 		} else {
-			utils.BuildResponse(c, http.StatusOK, nil, 2, "room not exist")
+			utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
 		}
 	} else {
 		utils.BuildResponse(c, http.StatusOK, nil, 1, "room id error")
@@ -64,11 +107,52 @@ func closeRoomCtrl(c *gin.Context) {
 		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
 		return
 	}
-	err = room.CloseRoom(params.RoomId, params.UserId)
+	result, err := room.CloseRoom(params.RoomId, getAuthUserId(c))
 	if err != nil {
 		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
 	} else {
-		utils.BuildResponseOk(c, nil)
+		utils.BuildResponseOk(c, buildSettlementResp(result))
+	}
+}
+
+// 房间结算结果：每个玩家的输赢以及转账方案
+func getSettlementCtrl(c *gin.Context) {
+	roomIdStr := c.DefaultQuery("room_id", "")
+	if roomId, err := strconv.Atoi(roomIdStr); err == nil {
+		result, err := room.GetSettlement(roomId, getAuthUserId(c))
+		if err == nil {
+			utils.BuildResponseOk(c, buildSettlementResp(result))
+		} else {
+			utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+		}
+	} else {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "room id error")
+	}
+}
+
+// 房主分页查看房间的审计日志，page从1开始
+func getAuditEventsCtrl(c *gin.Context) {
+	roomId, err := strconv.Atoi(c.DefaultQuery("room_id", ""))
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "room id error")
+		return
+	}
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "page error")
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(audit.DEFAULT_PAGE_SIZE)))
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "page size error")
+		return
+	}
+
+	events, err := room.GetAuditEvents(roomId, getAuthUserId(c), page, pageSize)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildAuditEventListResp(events, page))
 	}
 }
 
@@ -89,7 +173,7 @@ func entryRoomCtrl(c *gin.Context) {
 		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
 		return
 	}
-	res, err := room.EntryRoom(params.RoomId, params.UserId)
+	res, err := room.EntryRoom(params.RoomId, getAuthUserId(c))
 	if err != nil {
 		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
 	} else {
@@ -103,7 +187,7 @@ func leaveRoomCtrl(c *gin.Context) {
 		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
 		return
 	}
-	res, err := room.LeaveRoom(params.RoomId, params.UserId)
+	res, err := room.LeaveRoom(params.RoomId, getAuthUserId(c))
 	if err != nil {
 		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
 	} else {
@@ -118,7 +202,7 @@ func joinGameCtrl(c *gin.Context) {
 		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
 		return
 	}
-	res, err := room.JoinGame(params.RoomId, params.UserId)
+	res, err := room.JoinGame(params.RoomId, getAuthUserId(c))
 	if err != nil {
 		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
 	} else {
@@ -133,7 +217,7 @@ func quitGameCtrl(c *gin.Context) {
 		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
 		return
 	}
-	res, err := room.QuitGame(params.RoomId, params.UserId)
+	res, err := room.QuitGame(params.RoomId, getAuthUserId(c))
 	if err != nil {
 		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
 	} else {
@@ -141,10 +225,36 @@ func quitGameCtrl(c *gin.Context) {
 	}
 }
 
+// 等待时间支持"30s"这样的格式，也可以直接传秒数
+func getWaitTimeout(c *gin.Context) (time.Duration, error) {
+	waitStr := c.DefaultQuery("wait", "")
+	if waitStr == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(waitStr); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(waitStr)
+}
+
+// 带上since_version时，会等待房间版本号发生变化或超时后再返回
 func getRoomInfoCtrl(c *gin.Context) {
 	roomIdStr := c.DefaultQuery("room_id", "")
 	if roomId, err := strconv.Atoi(roomIdStr); err == nil {
-		roomInfo, err := room.GetRoomInfo(roomId)
+		sinceVersion, err := strconv.ParseInt(c.DefaultQuery("since_version", "-1"), 10, 64)
+		if err != nil {
+			utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "since version error")
+			return
+		}
+		wait, err := getWaitTimeout(c)
+		if err != nil {
+			utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "wait error")
+			return
+		}
+		if sinceVersion < 0 {
+			wait = 0
+		}
+		roomInfo, err := room.WaitRoomChange(c.Request.Context(), roomId, getAuthUserId(c), sinceVersion, wait)
 		if err == nil {
 			utils.BuildResponseOk(c, buildRoomInfoResp(roomInfo))
 		} else {
@@ -154,3 +264,144 @@ func getRoomInfoCtrl(c *gin.Context) {
 		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "room id error")
 	}
 }
+
+// owner set how many chips equal how much money
+func updateChipRateCtrl(c *gin.Context) {
+	params, err := buildRoomParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	money, err := decimal.NewFromString(params.Money)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, "money error")
+		return
+	}
+
+	roomInfo, err := room.UpdateChipRate(params.RoomId, getAuthUserId(c), room.ChipRate{
+		Chips:    params.Chips,
+		Money:    money,
+		Currency: params.Currency,
+		Scale:    params.Scale,
+		Rounding: params.Rounding,
+	})
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildRoomInfoResp(roomInfo))
+	}
+}
+
+func getMaxRebuys(params *roomRequestParams) int {
+	if params.MaxRebuys == nil {
+		return room.UNLIMITED_REBUYS
+	}
+	return *params.MaxRebuys
+}
+
+// owner set the buy-in limits and rebuy policy
+func updateBuyInPolicyCtrl(c *gin.Context) {
+	params, err := buildRoomParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	roomInfo, err := room.UpdateBuyInPolicy(params.RoomId, getAuthUserId(c), room.BuyInPolicy{
+		MinBuyIn:      params.MinBuyIn,
+		MaxBuyIn:      params.MaxBuyIn,
+		MaxTotalBuyIn: params.MaxTotalBuyIn,
+		MaxRebuys:     getMaxRebuys(params),
+		RebuyCooldown: params.RebuyCooldown,
+	})
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildRoomInfoResp(roomInfo))
+	}
+}
+
+// owner set how long a pending apply lasts before it expires
+func updateApplyTimeoutCtrl(c *gin.Context) {
+	params, err := buildRoomParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	roomInfo, err := room.UpdateApplyTimeout(params.RoomId, getAuthUserId(c), params.ApplyTimeout)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildRoomInfoResp(roomInfo))
+	}
+}
+
+// owner turn on or off the two-sided cash-out confirmation
+func updateTwoSidedCashOutCtrl(c *gin.Context) {
+	params, err := buildRoomParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	roomInfo, err := room.UpdateTwoSidedCashOut(params.RoomId, getAuthUserId(c), params.TwoSidedCashOut)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildRoomInfoResp(roomInfo))
+	}
+}
+
+// owner set the rule of auto approving buy-in applies
+func updateAutoApproveCtrl(c *gin.Context) {
+	params, err := buildRoomParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+
+	roomInfo, err := room.UpdateAutoApprove(params.RoomId, getAuthUserId(c), room.AutoApproveRule{
+		Enabled:   params.Enabled,
+		MaxChips:  params.MaxChips,
+		MaxRebuys: getMaxRebuys(params),
+		Roles:     params.Roles,
+		UserIds:   params.UserIds,
+	})
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildRoomInfoResp(roomInfo))
+	}
+}
+
+// owner grant a role to user in room
+func grantRoleCtrl(c *gin.Context) {
+	params, err := buildRoomParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+	err = room.GrantRole(params.RoomId, getAuthUserId(c), params.UserId, params.Role)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildBoolResp(true))
+	}
+}
+
+// owner revoke role, user becomes a regular player
+func revokeRoleCtrl(c *gin.Context) {
+	params, err := buildRoomParams(c)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusBadRequest, nil, 1, err.Error())
+		return
+	}
+	err = room.RevokeRole(params.RoomId, getAuthUserId(c), params.UserId)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+	} else {
+		utils.BuildResponseOk(c, buildBoolResp(true))
+	}
+}
@@ -7,25 +7,59 @@ import (
 
 func buildRouters(r *gin.Engine) {
 
-	// user
-	r.POST(utils.BuildRouterPath("v1", "user/update"), userUpdateCtrl)
+	// user: 登录前可以访问
 	r.POST(utils.BuildRouterPath("v1", "openid"), getOpenIdCtrl)
 	r.POST(utils.BuildRouterPath("v1", "user/check"), userCheckCtrl)
 	r.POST(utils.BuildRouterPath("v1", "user/register"), userRegisterCtrl)
 	r.POST(utils.BuildRouterPath("v1", "user/login"), userLoginCtrl)
 
+	// 以下接口需要登录，当前用户从登录凭证中获取
+	// 修改类的请求支持Idempotency-Key，重试时返回第一次请求的结果
+	auth := r.Group("/", authRequired, idempotencyRequired)
+
+	// user
+	auth.POST(utils.BuildRouterPath("v1", "user/update"), userUpdateCtrl)
+
 	// room
-	r.POST(utils.BuildRouterPath("v1", "room/create"), createRoomCtrl)
-	r.POST(utils.BuildRouterPath("v1", "room/user/entry"), entryRoomCtrl)
-	r.POST(utils.BuildRouterPath("v1", "room/user/game/join"), joinGameCtrl)
-	r.POST(utils.BuildRouterPath("v1", "room/user/game/quit"), quitGameCtrl)
-	r.POST(utils.BuildRouterPath("v1", "room/user/leave"), leaveRoomCtrl)
-	r.POST(utils.BuildRouterPath("v1", "room/close"), closeRoomCtrl)
-	r.GET(utils.BuildRouterPath("v1", "room/info"), getRoomInfoCtrl)
-	r.GET(utils.BuildRouterPath("v1", "room/check"), checkRoomCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/create"), createRoomCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/user/entry"), entryRoomCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/user/game/join"), joinGameCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/user/game/quit"), quitGameCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/user/leave"), leaveRoomCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/close"), closeRoomCtrl)
+	auth.GET(utils.BuildRouterPath("v1", "room/info"), getRoomInfoCtrl)
+	auth.GET(utils.BuildRouterPath("v1", "room/check"), checkRoomCtrl)
+	auth.GET(utils.BuildRouterPath("v1", "room/settlement"), getSettlementCtrl)
+	auth.GET(utils.BuildRouterPath("v1", "room/audit"), getAuditEventsCtrl)
+	auth.GET(utils.BuildRouterPath("v1", "room/ws"), roomWebsocketCtrl)
+	auth.GET(utils.BuildRouterPath("v1", "room/events"), roomEventsCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/rate/update"), updateChipRateCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/auto_approve/update"), updateAutoApproveCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/buy_in/update"), updateBuyInPolicyCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/apply_timeout/update"), updateApplyTimeoutCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/cashout/two_sided/update"), updateTwoSidedCashOutCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/role/grant"), grantRoleCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/role/revoke"), revokeRoleCtrl)
 
 	// records
-	r.POST(utils.BuildRouterPath("v1", "room/score/apply"), applyBuyInCtrl)
-	r.POST(utils.BuildRouterPath("v1", "room/score/confirm"), confirmBuyInCtrl)
-	r.GET(utils.BuildRouterPath("v1", "room/score/all"), getApplyScoreAllCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/apply"), applyBuyInCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/confirm"), confirmBuyInCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/confirm/batch"), confirmBuyInBatchCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/cancel"), cancelApplyCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/amend"), amendApplyCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/reverse"), reverseRecordCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/adjust"), adjustScoreCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/proxy"), proxyRecordCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/acknowledge"), acknowledgeCashOutCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/dispute"), disputeCashOutCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/resolve"), resolveDisputeCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/score/comment"), addCommentCtrl)
+	auth.GET(utils.BuildRouterPath("v1", "room/score/comments"), getCommentsCtrl)
+	auth.GET(utils.BuildRouterPath("v1", "room/score/all"), getApplyScoreAllCtrl)
+	auth.GET(utils.BuildRouterPath("v1", "room/score/history"), getRoomHistoryCtrl)
+	auth.GET(utils.BuildRouterPath("v1", "room/balance"), checkBalanceCtrl)
+	auth.POST(utils.BuildRouterPath("v1", "room/balance/resolve"), resolveBalanceCtrl)
+
+	// admin
+	r.POST(utils.BuildRouterPath("v1", "admin/recover"), adminAuth, recoverCtrl)
 }
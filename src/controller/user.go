@@ -18,19 +18,39 @@ type UserReq struct {
 	Code   string `json:"code,omitempty"`
 }
 
+type LoginResp struct {
+	PlayerInfoResp
+	Token string `json:"token"`
+}
+
+// 使用微信登录code换取openid和session_key，登录成功后签发登录凭证
 func userLoginCtrl(c *gin.Context) {
 	// 从请求体中读取 code
 	var params UserReq
-	if err := c.BindJSON(&params); err != nil {
+	if err := c.BindJSON(&params); err != nil || params.Code == "" {
 		utils.BuildResponse(c, http.StatusOK, nil, 1, "Invalid request body")
 		return
 	}
 
+	openid, sessionKey, err := utils.GetWechatOpenidAndSessionKey(params.Code)
+	if err != nil {
+		// 微信接口出错
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+		return
+	}
+
 	// 将用户信息载入，即为活跃状态
-	userInfo := user.UserLogin(params.Id)
+	userInfo, token, err := user.UserLogin(openid, sessionKey)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 3, err.Error())
+		return
+	}
 
 	// 返回用户信息给客户端
-	utils.BuildResponseOk(c, buildPlayerInfoResp(userInfo, 0))
+	utils.BuildResponseOk(c, LoginResp{
+		PlayerInfoResp: buildPlayerInfoResp(userInfo, 0),
+		Token:          token,
+	})
 }
 
 // 根据code获取openid，用于后续登录/注册
@@ -77,14 +97,24 @@ func userRegisterCtrl(c *gin.Context) {
 		return
 	}
 
-	if params.OpenId == "" || params.Name == "" {
+	if params.Code == "" || params.Name == "" {
 		utils.BuildResponse(c, http.StatusOK, nil, 1, "Invalid request body")
 		return
 	}
 
-	user, err := user.UserRegister(params.Name, params.OpenId)
+	// openid以微信返回的为准，不信任请求体中的openid
+	openid, sessionKey, err := utils.GetWechatOpenidAndSessionKey(params.Code)
+	if err != nil {
+		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
+		return
+	}
+
+	userInfo, token, err := user.UserRegister(params.Name, openid, sessionKey)
 	if err == nil {
-		utils.BuildResponseOk(c, buildPlayerInfoResp(user, 0))
+		utils.BuildResponseOk(c, LoginResp{
+			PlayerInfoResp: buildPlayerInfoResp(userInfo, 0),
+			Token:          token,
+		})
 	} else {
 		utils.BuildResponse(c, http.StatusOK, nil, 2, err.Error())
 	}
@@ -106,7 +136,7 @@ func userUpdateCtrl(c *gin.Context) {
 	}
 
 	client.User.UpsertOne(
-		db.User.ID.Equals(params.Id),
+		db.User.ID.Equals(getAuthUserId(c)),
 	).Update(
 		db.User.Name.Set(params.Name),
 	).Exec(context.Background())
@@ -29,7 +29,9 @@ func Init(router *gin.Engine) {
 
 	logs.Init()
 	controller.Init(router)
-	model.Init()
+	if err := model.Init(); err != nil {
+		log.Fatalf("Error init model: %v", err)
+	}
 }
 
 func close(router *gin.Engine) {
@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jianshao/poker_counter/prisma/db"
+	"github.com/jianshao/poker_counter/src/utils/logs"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+// 审计的操作类型
+const (
+	ACTION_CREATE_ROOM   = "create_room"
+	ACTION_ENTRY_ROOM    = "entry_room"
+	ACTION_JOIN_GAME     = "join_game"
+	ACTION_QUIT_GAME     = "quit_game"
+	ACTION_LEAVE_ROOM    = "leave_room"
+	ACTION_APPLY         = "apply"
+	ACTION_CONFIRM_APPLY = "confirm_apply"
+	ACTION_CANCEL_APPLY  = "cancel_apply"
+	ACTION_AMEND_APPLY   = "amend_apply"
+	ACTION_EXPIRE_APPLY  = "expire_apply" // 定时任务处理超时未审批的申请
+	ACTION_REVERSE       = "reverse_record"
+	ACTION_ADJUST        = "adjust_score"
+	ACTION_PROXY         = "proxy_record"
+	ACTION_DISPUTE       = "dispute_cashout"
+	ACTION_RESOLVE       = "resolve_dispute"
+	ACTION_COMMENT       = "add_comment"
+	ACTION_BALANCE       = "resolve_balance" // 处理积分差额生成的平账记录
+	ACTION_GRANT_ROLE    = "grant_role"
+	ACTION_REVOKE_ROLE   = "revoke_role"
+	ACTION_UPDATE_ROOM   = "update_room" // 修改房间设置，状态中的key为设置项
+	ACTION_CLOSE_ROOM    = "close_room"
+	ACTION_CLEAR_ROOM    = "clear_room" // 定时任务关闭长时间未使用的房间
+
+	// 操作对象的类型
+	TARGET_ROOM    = "room"
+	TARGET_USER    = "user"
+	TARGET_APPLY   = "apply"
+	TARGET_COMMENT = "comment"
+
+	// 系统任务的操作者
+	ACTOR_SYSTEM = 0
+
+	DEFAULT_PAGE_SIZE = 20
+	MAX_PAGE_SIZE     = 100
+)
+
+// 房间的状态
+type RoomState struct {
+	Status int `json:"status"`
+	Code   int `json:"code"`
+}
+
+// 玩家在房间内的状态
+type PlayerState struct {
+	InRoom bool `json:"in_room"`
+	Status int  `json:"status"`
+	Role   int  `json:"role"`
+}
+
+// 申请的状态
+type ApplyState struct {
+	UserId    int    `json:"user_id"`
+	Status    int    `json:"status"`
+	ApplyType int    `json:"apply_type"`
+	Score     int    `json:"score"`
+	RefId     int    `json:"ref_id,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type Event struct {
+	Id         int
+	RoomId     int
+	Actor      int
+	Action     string
+	TargetType string
+	TargetId   int
+	Before     json.RawMessage
+	After      json.RawMessage
+	CreateTime string
+}
+
+func marshalState(state interface{}) []byte {
+	if state == nil {
+		return []byte("{}")
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return []byte("{}")
+	}
+	return data
+}
+
+// 在操作生效之后记录，写入失败只记录日志，不影响已经生效的操作
+func Record(roomId, actor int, action, targetType string, targetId int, before, after interface{}) {
+	_, err := view.InsertAuditEvent(roomId, actor, action, targetType, targetId, marshalState(before), marshalState(after))
+	if err != nil {
+		logs.Error(nil, fmt.Sprintf("record audit %s of room %d failed: %s", action, roomId, err.Error()))
+	}
+}
+
+func buildEvent(event *db.AuditEventModel) *Event {
+	return &Event{
+		Id:         event.ID,
+		RoomId:     event.RoomID,
+		Actor:      event.Actor,
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetId:   event.TargetID,
+		Before:     json.RawMessage(event.Before),
+		After:      json.RawMessage(event.After),
+		CreateTime: event.CreatedTime.String(),
+	}
+}
+
+// 房间的审计日志，按时间倒序，page从1开始
+func GetRoomEvents(roomId, page, pageSize int) ([]Event, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = DEFAULT_PAGE_SIZE
+	}
+	if pageSize > MAX_PAGE_SIZE {
+		pageSize = MAX_PAGE_SIZE
+	}
+
+	events, err := view.GetAuditEvents(roomId, (page-1)*pageSize, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	result := []Event{}
+	for _, event := range events {
+		result = append(result, *buildEvent(&event))
+	}
+	return result, nil
+}
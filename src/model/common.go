@@ -1,8 +1,17 @@
 package model
 
-import "github.com/jianshao/poker_counter/src/model/schedule"
+import (
+	"github.com/jianshao/poker_counter/src/model/room"
+	"github.com/jianshao/poker_counter/src/model/schedule"
+)
 
 func Init() error {
-	schedule.Init()
-	return nil
+	if err := room.Init(); err != nil {
+		return err
+	}
+	// 启动时从database恢复未关闭的房间
+	if err := room.Recover(); err != nil {
+		return err
+	}
+	return schedule.Init()
 }
@@ -0,0 +1,197 @@
+package event
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jianshao/poker_counter/src/model/records"
+)
+
+// 房间内的状态变化事件
+const (
+	EVENT_ENTRY_ROOM  = "entry_room"
+	EVENT_LEAVE_ROOM  = "leave_room"
+	EVENT_JOIN_GAME   = "join_game"
+	EVENT_QUIT_GAME   = "quit_game"
+	EVENT_APPLY       = "apply"
+	EVENT_CONFIRM     = "confirm"
+	EVENT_CANCEL      = "cancel"
+	EVENT_AMEND       = "amend"
+	EVENT_EXPIRE      = "expire"
+	EVENT_ACKNOWLEDGE = "acknowledge"
+	EVENT_DISPUTE     = "dispute"
+	EVENT_RESOLVE     = "resolve"
+	EVENT_COMMENT     = "comment"
+	EVENT_REVERSE     = "reverse"
+	EVENT_ADJUST      = "adjust"
+	EVENT_PROXY       = "proxy"
+	EVENT_CLOSE_ROOM  = "close_room"
+	EVENT_UPDATE_ROOM = "update_room"
+	EVENT_ROLE        = "role"
+	EVENT_BALANCE     = "balance"
+	EVENT_RECOVER     = "recover"
+
+	// 订阅者的缓冲区大小，缓冲区满说明订阅者处理不过来，会被断开
+	SUBSCRIBER_BUFFER = 64
+	// 每个房间保留最近的事件数量，用于断线后补发
+	ROOM_EVENT_BUFFER = 256
+)
+
+type Event struct {
+	Id      int64 // 房间内单调递增
+	RoomId  int
+	Version int64 // 事件发生后房间的版本号
+	Type    string
+	UserId  int
+	Apply   *records.ApplyScore // 申请相关的事件携带申请的快照
+}
+
+type Subscriber struct {
+	RoomId int
+	C      chan *Event
+	closed bool
+}
+
+// 每个房间的事件序号、最近事件和订阅者
+type roomEvents struct {
+	seq         int64
+	base        int64 // 本进程内该房间的起始序号，之前的事件无法补发
+	buffer      []*Event
+	subscribers map[*Subscriber]bool
+	closed      bool
+}
+
+type hub struct {
+	rooms map[int]*roomEvents
+	lock  sync.Mutex
+}
+
+var (
+	gHub = &hub{
+		rooms: map[int]*roomEvents{},
+	}
+)
+
+// 序号以毫秒时间戳*1000为起点，进程重启后的序号仍然大于重启前的序号
+func getRoomEvents(roomId int) *roomEvents {
+	room, ok := gHub.rooms[roomId]
+	if !ok {
+		base := time.Now().UnixMilli() * 1000
+		room = &roomEvents{
+			seq:         base,
+			base:        base,
+			buffer:      []*Event{},
+			subscribers: map[*Subscriber]bool{},
+		}
+		gHub.rooms[roomId] = room
+	}
+	return room
+}
+
+// 没有订阅者时，房间已关闭或者没有可补发的事件就释放资源
+func releaseRoomEvents(roomId int, room *roomEvents) {
+	if len(room.subscribers) == 0 && (room.closed || len(room.buffer) == 0) {
+		delete(gHub.rooms, roomId)
+	}
+}
+
+// 房间被关闭或清理时调用，仍有订阅者时等订阅者全部退出后释放
+func ReleaseRoom(roomId int) {
+	gHub.lock.Lock()
+	defer gHub.lock.Unlock()
+	room, ok := gHub.rooms[roomId]
+	if !ok {
+		return
+	}
+	room.closed = true
+	releaseRoomEvents(roomId, room)
+}
+
+// 订阅房间事件，使用完需要调用Unsubscribe
+func Subscribe(roomId int) *Subscriber {
+	sub, _, _ := SubscribeFrom(roomId, 0)
+	return sub
+}
+
+// 订阅房间事件，同时返回lastId之后的事件；lastId之后的事件已经无法全部补发时返回false
+func SubscribeFrom(roomId int, lastId int64) (*Subscriber, []*Event, bool) {
+	sub := &Subscriber{
+		RoomId: roomId,
+		C:      make(chan *Event, SUBSCRIBER_BUFFER),
+	}
+
+	gHub.lock.Lock()
+	defer gHub.lock.Unlock()
+	room := getRoomEvents(roomId)
+	room.subscribers[sub] = true
+
+	if lastId <= 0 {
+		return sub, nil, true
+	}
+	if lastId < room.base || (len(room.buffer) > 0 && lastId < room.buffer[0].Id-1) {
+		return sub, nil, false
+	}
+	events := []*Event{}
+	for _, e := range room.buffer {
+		if e.Id > lastId {
+			events = append(events, e)
+		}
+	}
+	return sub, events, true
+}
+
+// 房间当前最新的事件序号，只读取不创建缓冲区
+func LastId(roomId int) int64 {
+	gHub.lock.Lock()
+	defer gHub.lock.Unlock()
+	if room, ok := gHub.rooms[roomId]; ok {
+		return room.seq
+	}
+	// 之后创建的缓冲区起始序号不会小于该值
+	return time.Now().UnixMilli() * 1000
+}
+
+func removeSubscriber(room *roomEvents, sub *Subscriber) {
+	delete(room.subscribers, sub)
+	if !sub.closed {
+		sub.closed = true
+		close(sub.C)
+	}
+}
+
+func Unsubscribe(sub *Subscriber) {
+	gHub.lock.Lock()
+	defer gHub.lock.Unlock()
+	room, ok := gHub.rooms[sub.RoomId]
+	if !ok {
+		return
+	}
+	removeSubscriber(room, sub)
+	releaseRoomEvents(sub.RoomId, room)
+}
+
+// 推送事件给房间的所有订阅者，不会阻塞调用方
+func Publish(event *Event) {
+	gHub.lock.Lock()
+	defer gHub.lock.Unlock()
+	room := getRoomEvents(event.RoomId)
+	room.seq += 1
+	event.Id = room.seq
+
+	room.buffer = append(room.buffer, event)
+	if len(room.buffer) > ROOM_EVENT_BUFFER {
+		room.buffer = room.buffer[len(room.buffer)-ROOM_EVENT_BUFFER:]
+	}
+	if event.Type == EVENT_CLOSE_ROOM {
+		room.closed = true
+	}
+
+	for sub := range room.subscribers {
+		select {
+		case sub.C <- event:
+		default:
+			removeSubscriber(room, sub)
+		}
+	}
+	releaseRoomEvents(event.RoomId, room)
+}
@@ -0,0 +1,46 @@
+package records
+
+import (
+	"github.com/jianshao/poker_counter/prisma/db"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+// 积分记录下的留言
+type Comment struct {
+	Id         int
+	ApplyId    int
+	UserId     int
+	Content    string
+	CreateTime string
+}
+
+func buildComment(comment *db.ScoreCommentModel) *Comment {
+	return &Comment{
+		Id:         comment.ID,
+		ApplyId:    comment.RecordID,
+		UserId:     comment.UID,
+		Content:    comment.Content,
+		CreateTime: comment.CreatedTime.String(),
+	}
+}
+
+func AddComment(applyId, userId int, content string) (*Comment, error) {
+	comment, err := view.InsertScoreComment(applyId, userId, content)
+	if err != nil {
+		return nil, err
+	}
+	return buildComment(comment), nil
+}
+
+// 记录下的所有留言，按时间顺序
+func GetComments(applyId int) ([]Comment, error) {
+	comments, err := view.GetScoreComments(applyId)
+	if err != nil {
+		return nil, err
+	}
+	result := []Comment{}
+	for _, comment := range comments {
+		result = append(result, *buildComment(&comment))
+	}
+	return result, nil
+}
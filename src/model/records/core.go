@@ -2,7 +2,10 @@ package records
 
 import (
 	"errors"
+	"sync"
+	"time"
 
+	"github.com/jianshao/poker_counter/prisma/db"
 	"github.com/jianshao/poker_counter/src/view"
 )
 
@@ -16,25 +19,95 @@ type ApplyScore struct {
 	ApplyType   int
 	ApplyTime   string
 	ConfirmTime string
+	ApplyAt     time.Time // 申请时间，用于计算补码间隔
+	RefId       int       // 冲正记录指向的原记录
+	Reason      string    // 手动调整的原因
+	CreatedBy   int       // 代玩家录入时为操作者，玩家自己提交时为0
+	Auto        bool      // 按自动审批规则通过
+}
+
+const (
+	// 申请类型
+	APPLY_TYPE_BUYIN   = 0
+	APPLY_TYPE_CASHOUT = 1
+	APPLY_TYPE_BALANCE = 2 // 平账记录，由房主处理积分不平时产生
+	APPLY_TYPE_ADJUST  = 3 // 房主或副房主的手动调整，需要填写原因
+
+	// 申请状态
+	APPLY_STATUS_APPLY  = 0
+	APPLY_STATUS_ACCEPT = 1
+	APPLY_STATUS_REJECT = 2
+	// 玩家在审批前撤回
+	APPLY_STATUS_CANCELLED = 3
+	// 超过房间的超时时间未审批
+	APPLY_STATUS_EXPIRED = 4
+	// 结算有争议，等待房主处理
+	APPLY_STATUS_DISPUTED = 5
+)
+
+// 审批后玩家在房间内的积分，由数据库和审批结果一起更新
+type PlayerScore struct {
+	CurrScore  int
+	FinalScore int
+}
+
+// 房间已确认积分的汇总
+type Balance struct {
+	RoomId  int
+	BuyIn   int
+	CashOut int
+	Adjust  int // 所有平账记录之和
+	Manual  int // 所有手动调整之和
+	House   int // 记在房间（而非玩家）名下的平账
+	Diff    int // 结算+平账+手动调整-买入，为0时房间积分平衡
+}
+
+// 根据记录重放得到的玩家积分
+type Replay struct {
+	UserId     int
+	CurrScore  int
+	FinalScore int
+	CashedOut  bool
+	ApplyList  map[int]int
 }
 
 var (
+	// 申请已经被审批过（可能是其他服务实例）
+	ErrApplyProcessed = errors.New("申请已被处理")
+	// 记录已经被冲正过
+	ErrRecordReversed = errors.New("记录已被冲正")
+	// 买入积分必须大于0
+	ErrInvalidBuyIn = errors.New("买入积分必须大于0")
+	// 结算积分不能为负数
+	ErrNegativeCashOut = errors.New("结算积分不能为负数")
+
 	gAppliesMap = map[int]*ApplyScore{}
+	// 正在审批或修改的申请，保证同一个申请只会被处理一次
+	gConfirming  = map[int]bool{}
+	gAppliesLock sync.RWMutex
 )
 
 func Init() {
 
 }
 
+// 返回申请的副本，缓存中的申请只在本包内修改
 func GetApply(applyId int) (*ApplyScore, error) {
-	if apply, ok := gAppliesMap[applyId]; ok {
-		return apply, nil
+	gAppliesLock.RLock()
+	apply, ok := gAppliesMap[applyId]
+	if ok {
+		applyCopy := *apply
+		gAppliesLock.RUnlock()
+		return &applyCopy, nil
 	}
+	gAppliesLock.RUnlock()
 	// TODO:
 	return loadApply(applyId)
 }
 
 func addApply(applyId int, apply *ApplyScore) error {
+	gAppliesLock.Lock()
+	defer gAppliesLock.Unlock()
 	if _, ok := gAppliesMap[applyId]; !ok {
 		gAppliesMap[applyId] = apply
 		return nil
@@ -49,3 +122,50 @@ func loadApply(applyId int) (*ApplyScore, error) {
 	}
 	return buildApplyScore(apply), nil
 }
+
+func setApply(apply *ApplyScore) {
+	gAppliesLock.Lock()
+	defer gAppliesLock.Unlock()
+	gAppliesMap[apply.Id] = apply
+}
+
+// 检查申请仍未审批并标记为处理中，并发处理同一个申请时只有一个能成功
+func claimApply(applyId int) (*ApplyScore, error) {
+	return claimApplyInStatus(applyId, APPLY_STATUS_APPLY)
+}
+
+// 检查申请处于指定状态并标记为处理中
+func claimApplyInStatus(applyId, status int) (*ApplyScore, error) {
+	gAppliesLock.RLock()
+	_, ok := gAppliesMap[applyId]
+	gAppliesLock.RUnlock()
+	if !ok {
+		loaded, err := loadApply(applyId)
+		if err != nil {
+			return nil, err
+		}
+		addApply(applyId, loaded)
+	}
+
+	gAppliesLock.Lock()
+	defer gAppliesLock.Unlock()
+	apply := gAppliesMap[applyId]
+	if apply.Status != status || gConfirming[applyId] {
+		return nil, ErrApplyProcessed
+	}
+	gConfirming[applyId] = true
+	applyCopy := *apply
+	return &applyCopy, nil
+}
+
+// 处理结束，用数据库中的记录更新缓存；记录为空时缓存保持不变
+func finishApply(applyId int, record *db.ScoreRecordsModel) *ApplyScore {
+	gAppliesLock.Lock()
+	defer gAppliesLock.Unlock()
+	delete(gConfirming, applyId)
+	if record != nil {
+		gAppliesMap[applyId] = buildApplyScore(record)
+	}
+	applyCopy := *gAppliesMap[applyId]
+	return &applyCopy
+}
@@ -17,14 +17,42 @@ func buildApplyScore(apply *db.ScoreRecordsModel) *ApplyScore {
 		ApplyType:   view.Type2int[apply.Type],
 		ApplyTime:   apply.CreatedTime.String(),
 		ConfirmTime: apply.UpdatedTime.String(),
+		ApplyAt:     apply.CreatedTime,
 	}
-	if view.Status2int[apply.Status] != 1 {
+	if view.Status2int[apply.Status] != APPLY_STATUS_ACCEPT {
 		record.ConfirmTime = ""
 	}
+	if refId, ok := apply.RefID(); ok {
+		record.RefId = refId
+	}
+	record.Reason = apply.Reason
+	record.CreatedBy = apply.CreatedBy
+	record.Auto = apply.Auto
 	return record
 }
 
+// 玩家提交或代录的买入和结算积分检查
+func CheckApplyScore(score, applyType int) error {
+	switch applyType {
+	case APPLY_TYPE_BUYIN:
+		if score <= 0 {
+			return ErrInvalidBuyIn
+		}
+	case APPLY_TYPE_CASHOUT:
+		if score < 0 {
+			return ErrNegativeCashOut
+		}
+	default:
+		return errors.New("apply type error")
+	}
+	return nil
+}
+
 func ApplyBuyIn(roomId, userId, score, applyType int) (*ApplyScore, error) {
+	if err := CheckApplyScore(score, applyType); err != nil {
+		return nil, err
+	}
+
 	// 往数据库中插入一条数据
 	applyData, err := view.InsertScoreApply(roomId, userId, score, applyType)
 	if err != nil {
@@ -34,31 +62,208 @@ func ApplyBuyIn(roomId, userId, score, applyType int) (*ApplyScore, error) {
 	apply := buildApplyScore(applyData)
 	addApply(apply.Id, apply)
 
-	return apply, nil
+	applyCopy := *apply
+	return &applyCopy, nil
+}
+
+// 审批申请，数据库中只有仍处于申请状态的记录会被修改，同意时一并更新玩家的积分
+// 玩家记录不存在或拒绝时返回的积分为空
+func ConfirmBuyIn(applyId, status int) (*ApplyScore, *PlayerScore, error) {
+	if status != APPLY_STATUS_ACCEPT && status != APPLY_STATUS_REJECT {
+		return nil, nil, errors.New("apply status error")
+	}
+	return confirmApply(applyId, status, false)
+}
+
+// 按自动审批规则通过申请，记录上会标记为自动通过
+func AutoAcceptApply(applyId int) (*ApplyScore, *PlayerScore, error) {
+	return confirmApply(applyId, APPLY_STATUS_ACCEPT, true)
+}
+
+func confirmApply(applyId, status int, auto bool) (*ApplyScore, *PlayerScore, error) {
+	return settleApply(applyId, APPLY_STATUS_APPLY, func() (*view.ConfirmedRecord, error) {
+		return view.ConfirmScoreApply(applyId, status, auto)
+	})
+}
+
+// 房主处理有争议的结算，同意时按处理后的积分计入玩家的结算积分
+func ResolveDispute(applyId, status, score int) (*ApplyScore, *PlayerScore, error) {
+	if status != APPLY_STATUS_ACCEPT && status != APPLY_STATUS_REJECT {
+		return nil, nil, errors.New("apply status error")
+	}
+	if status == APPLY_STATUS_ACCEPT && score < 0 {
+		return nil, nil, ErrNegativeCashOut
+	}
+	return settleApply(applyId, APPLY_STATUS_DISPUTED, func() (*view.ConfirmedRecord, error) {
+		return view.ResolveScoreDispute(applyId, status, score)
+	})
+}
+
+// 审批和处理争议的公共流程，只处理仍为fromStatus状态的记录
+func settleApply(applyId, fromStatus int, update func() (*view.ConfirmedRecord, error)) (*ApplyScore, *PlayerScore, error) {
+	if _, err := claimApplyInStatus(applyId, fromStatus); err != nil {
+		return nil, nil, err
+	}
+
+	record, err := update()
+	if err == db.ErrNotFound {
+		// 已被其他服务实例处理，以数据库为准刷新缓存
+		latest, _ := view.GetScoreRecordById(applyId)
+		finishApply(applyId, latest)
+		return nil, nil, ErrApplyProcessed
+	}
+	if err != nil {
+		finishApply(applyId, nil)
+		return nil, nil, err
+	}
+
+	// 更新本地缓存
+	apply := finishApply(applyId, &record.ScoreRecordsModel)
+	if record.CurrScore == nil || record.FinalScore == nil {
+		return apply, nil, nil
+	}
+	return apply, &PlayerScore{
+		CurrScore:  *record.CurrScore,
+		FinalScore: *record.FinalScore,
+	}, nil
 }
 
-func ConfirmBuyIn(applyId, status int) (*ApplyScore, error) {
+// 玩家修改自己仍处于申请状态的记录，与审批互斥
+func updateOwnApply(applyId, userId int, update func() (*db.ScoreRecordsModel, error)) (*ApplyScore, error) {
+	return updateApply(applyId, func(apply *ApplyScore) error {
+		if apply.UserId != userId {
+			return errors.New("apply not belong to user")
+		}
+		return nil
+	}, update)
+}
+
+// 修改仍处于申请状态的记录，check不通过时不修改
+func updateApply(applyId int, check func(apply *ApplyScore) error, update func() (*db.ScoreRecordsModel, error)) (*ApplyScore, error) {
+	apply, err := claimApply(applyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(apply); err != nil {
+		finishApply(applyId, nil)
+		return nil, err
+	}
+
+	record, err := update()
+	if err == db.ErrNotFound {
+		// 已被其他服务实例处理，以数据库为准刷新缓存
+		latest, _ := view.GetScoreRecordById(applyId)
+		finishApply(applyId, latest)
+		return nil, ErrApplyProcessed
+	}
+	if err != nil {
+		finishApply(applyId, nil)
+		return nil, err
+	}
+	return finishApply(applyId, record), nil
+}
+
+// 撤回申请，只能在审批前撤回
+func CancelApply(applyId, userId int) (*ApplyScore, error) {
+	return updateOwnApply(applyId, userId, func() (*db.ScoreRecordsModel, error) {
+		return view.CancelScoreApply(applyId, userId)
+	})
+}
+
+// 结算有争议，只有结算申请可以提出争议，留言和争议一起写入
+func DisputeApply(applyId, userId int, content string) (*ApplyScore, error) {
+	return updateApply(applyId, func(apply *ApplyScore) error {
+		if apply.ApplyType != APPLY_TYPE_CASHOUT {
+			return errors.New("只能对结算提出争议")
+		}
+		return nil
+	}, func() (*db.ScoreRecordsModel, error) {
+		return view.DisputeScoreApply(applyId, userId, content)
+	})
+}
+
+// 超时未审批的申请过期，userId为申请的玩家
+func ExpireApply(applyId, userId int) (*ApplyScore, error) {
+	return updateOwnApply(applyId, userId, func() (*db.ScoreRecordsModel, error) {
+		return view.ExpireScoreApply(applyId, userId)
+	})
+}
+
+// 所有超时未审批的申请
+func GetExpiredApplies() ([]ApplyScore, error) {
+	records, err := view.GetExpiredScoreApplies()
+	if err != nil {
+		return nil, err
+	}
+	applies := []ApplyScore{}
+	for _, record := range records {
+		applies = append(applies, *buildApplyScore(&record))
+	}
+	return applies, nil
+}
+
+// 修改申请的积分，只能在审批前修改
+func AmendApply(applyId, userId, score int) (*ApplyScore, error) {
 	apply, err := GetApply(applyId)
 	if err != nil {
 		return nil, err
 	}
-	if apply.Status != 0 {
-		return nil, errors.New("apply status error")
+	if err := CheckApplyScore(score, apply.ApplyType); err != nil {
+		return nil, err
 	}
-	// 更新数据库
-	newApply, err := view.UpdateScoreApply(applyId, status)
+	return updateOwnApply(applyId, userId, func() (*db.ScoreRecordsModel, error) {
+		return view.AmendScoreApply(applyId, userId, score)
+	})
+}
+
+// 冲正已确认的买入或结算记录，生成一条积分相反的记录，原记录保持不变
+func ReverseRecord(applyId int) (*ApplyScore, error) {
+	apply, err := GetApply(applyId)
 	if err != nil {
 		return nil, err
 	}
+	if apply.Status != APPLY_STATUS_ACCEPT || apply.RefId != 0 {
+		return nil, errors.New("只能冲正已确认的记录")
+	}
+	if apply.ApplyType != APPLY_TYPE_BUYIN && apply.ApplyType != APPLY_TYPE_CASHOUT {
+		return nil, errors.New("只能冲正买入和结算记录")
+	}
 
-	// 更新本地缓存
-	apply.Status = status
-	apply.ConfirmTime = newApply.UpdatedTime.String()
-	return apply, nil
+	record, err := view.InsertReversalRecord(apply.RoomId, apply.UserId, apply.Score, apply.ApplyType, apply.Id)
+	if err != nil {
+		if _, ok := db.IsErrUniqueConstraint(err); ok {
+			return nil, ErrRecordReversed
+		}
+		return nil, err
+	}
+
+	reversal := buildApplyScore(record)
+	addApply(reversal.Id, reversal)
+
+	reversalCopy := *reversal
+	return &reversalCopy, nil
 }
 
-func GetApplyScoreAll(roomId int) ([]ApplyScore, error) {
-	records, err := view.GetScoreRecords(roomId, 0)
+// 等待对方确认或有争议的结算
+func IsPendingCashOut(apply *ApplyScore) bool {
+	if apply.ApplyType != APPLY_TYPE_CASHOUT {
+		return false
+	}
+	return apply.Status == APPLY_STATUS_APPLY || apply.Status == APPLY_STATUS_DISPUTED
+}
+
+// 房间内有争议的结算数量
+func CountDisputes(roomId int) (int, error) {
+	records, err := view.GetScoreRecords(roomId, APPLY_STATUS_DISPUTED)
+	if err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// 房间内的所有记录，按创建时间顺序
+func GetRoomHistory(roomId int) ([]ApplyScore, error) {
+	records, err := view.GetRoomScoreRecords(roomId)
 	if err != nil {
 		return nil, err
 	}
@@ -69,3 +274,170 @@ func GetApplyScoreAll(roomId int) ([]ApplyScore, error) {
 	}
 	return applies, nil
 }
+
+// 待审批的申请，以及所有手动调整
+func GetApplyScoreAll(roomId int) ([]ApplyScore, error) {
+	records, err := view.GetScoreRecords(roomId, APPLY_STATUS_APPLY)
+	if err != nil {
+		return nil, err
+	}
+	disputes, err := view.GetScoreRecords(roomId, APPLY_STATUS_DISPUTED)
+	if err != nil {
+		return nil, err
+	}
+	adjustments, err := view.GetScoreRecordsByType(roomId, APPLY_TYPE_ADJUST)
+	if err != nil {
+		return nil, err
+	}
+
+	applies := []ApplyScore{}
+	records = append(records, disputes...)
+	for _, record := range append(records, adjustments...) {
+		applies = append(applies, *buildApplyScore(&record))
+	}
+	return applies, nil
+}
+
+// 平账记录直接生效，userId为0时记在房间名下
+func AddBalance(roomId, userId, score int) (*ApplyScore, error) {
+	record, err := view.InsertScoreRecord(roomId, userId, score, APPLY_TYPE_BALANCE, APPLY_STATUS_ACCEPT)
+	if err != nil {
+		return nil, err
+	}
+
+	apply := buildApplyScore(record)
+	addApply(apply.Id, apply)
+
+	applyCopy := *apply
+	return &applyCopy, nil
+}
+
+// 一次写入多个玩家的平账记录，全部写入成功或全部不写入
+func AddBalances(roomId int, shares map[int]int) ([]ApplyScore, error) {
+	records, err := view.InsertBalanceRecords(roomId, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	applies := []ApplyScore{}
+	for i := range records {
+		apply := buildApplyScore(&records[i])
+		addApply(apply.Id, apply)
+		applies = append(applies, *apply)
+	}
+	return applies, nil
+}
+
+// 代玩家录入的买入或结算直接生效，不需要审批
+// pending为true时记录为申请状态，需要玩家确认后才生效
+func AddProxyRecord(roomId, userId, score, applyType, operator int, pending bool) (*ApplyScore, error) {
+	if err := CheckApplyScore(score, applyType); err != nil {
+		return nil, err
+	}
+	status := APPLY_STATUS_ACCEPT
+	if pending {
+		status = APPLY_STATUS_APPLY
+	}
+	record, err := view.InsertProxyRecord(roomId, userId, score, applyType, status, operator)
+	if err != nil {
+		return nil, err
+	}
+
+	apply := buildApplyScore(record)
+	addApply(apply.Id, apply)
+
+	applyCopy := *apply
+	return &applyCopy, nil
+}
+
+// 手动调整直接生效，计入玩家的结算积分
+func AddAdjustment(roomId, userId, score int, reason string) (*ApplyScore, error) {
+	record, err := view.InsertAdjustmentRecord(roomId, userId, score, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	apply := buildApplyScore(record)
+	addApply(apply.Id, apply)
+
+	applyCopy := *apply
+	return &applyCopy, nil
+}
+
+// 汇总房间内所有已确认的记录
+func GetRoomBalance(roomId int) (*Balance, error) {
+	records, err := view.GetScoreRecords(roomId, APPLY_STATUS_ACCEPT)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := &Balance{RoomId: roomId}
+	for _, record := range records {
+		switch view.Type2int[record.Type] {
+		case APPLY_TYPE_BUYIN:
+			balance.BuyIn += record.Score
+		case APPLY_TYPE_CASHOUT:
+			balance.CashOut += record.Score
+		case APPLY_TYPE_BALANCE:
+			balance.Adjust += record.Score
+			if record.UID == 0 {
+				balance.House += record.Score
+			}
+		case APPLY_TYPE_ADJUST:
+			balance.Manual += record.Score
+		}
+	}
+	balance.Diff = balance.CashOut + balance.Adjust + balance.Manual - balance.BuyIn
+	return balance, nil
+}
+
+// 按时间顺序重放房间内的所有记录，重建每个玩家的积分和申请列表
+func ReplayRoom(roomId int) (map[int]*Replay, error) {
+	records, err := view.GetRoomScoreRecords(roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	replays := map[int]*Replay{}
+	for _, record := range records {
+		apply := buildApplyScore(&record)
+		cached := *apply
+		setApply(&cached)
+		// 撤回和过期的申请不计入玩家的申请列表
+		if apply.Status == APPLY_STATUS_CANCELLED || apply.Status == APPLY_STATUS_EXPIRED {
+			continue
+		}
+
+		// 记在房间名下的平账不属于任何玩家
+		if record.UID == 0 {
+			continue
+		}
+		replay, ok := replays[record.UID]
+		if !ok {
+			replay = &Replay{
+				UserId:    record.UID,
+				ApplyList: map[int]int{},
+			}
+			replays[record.UID] = replay
+		}
+		replay.ApplyList[apply.Id] = apply.Id
+
+		// 代录的结算等待玩家确认或有争议时，玩家已经结束游戏
+		if IsPendingCashOut(apply) && apply.CreatedBy != 0 {
+			replay.CashedOut = true
+		}
+		if apply.Status != APPLY_STATUS_ACCEPT {
+			continue
+		}
+		switch apply.ApplyType {
+		case APPLY_TYPE_BUYIN:
+			replay.CurrScore += apply.Score
+		case APPLY_TYPE_CASHOUT:
+			replay.FinalScore += apply.Score
+			replay.CashedOut = true
+		default:
+			replay.FinalScore += apply.Score
+		}
+	}
+	return replays, nil
+}
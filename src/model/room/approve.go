@@ -0,0 +1,118 @@
+package room
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/jianshao/poker_counter/src/model/audit"
+	"github.com/jianshao/poker_counter/src/model/event"
+	"github.com/jianshao/poker_counter/src/model/records"
+	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/utils/logs"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+// 买入申请的自动审批规则，满足所有条件的申请直接通过
+type AutoApproveRule struct {
+	Enabled   bool
+	MaxChips  int   // 单次买入不超过该积分，0为不限制
+	MaxRebuys int   // 首次买入之后最多自动通过的次数，-1为不限制
+	Roles     []int // 指定角色的玩家可以自动通过
+	UserIds   []int // 白名单中的玩家可以自动通过，角色和白名单都为空时不限制玩家
+}
+
+func parseAutoApproveRule(data []byte) AutoApproveRule {
+	rule := AutoApproveRule{}
+	if len(data) > 0 {
+		json.Unmarshal(data, &rule)
+	}
+	return rule
+}
+
+func checkAutoApproveRule(rule *AutoApproveRule) error {
+	if rule.MaxChips < 0 {
+		return errors.New("积分上限不能为负数")
+	}
+	if rule.MaxRebuys < UNLIMITED_REBUYS {
+		return errors.New("补码次数错误")
+	}
+	for _, role := range rule.Roles {
+		if _, ok := rolePermissions[role]; !ok {
+			return errors.New("role error")
+		}
+	}
+	return nil
+}
+
+func containsInt(values []int, target int) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断申请是否满足自动审批规则，只处理买入申请，调用方需要持有房间锁
+func matchAutoApproveRule(room *RoomInfo, apply *records.ApplyScore) bool {
+	rule := &room.AutoApprove
+	if !rule.Enabled || apply.ApplyType != records.APPLY_TYPE_BUYIN {
+		return false
+	}
+	if rule.MaxChips > 0 && apply.Score > rule.MaxChips {
+		return false
+	}
+	if len(rule.Roles) > 0 || len(rule.UserIds) > 0 {
+		role, _ := getRole(room, apply.UserId)
+		if !containsInt(rule.Roles, role) && !containsInt(rule.UserIds, apply.UserId) {
+			return false
+		}
+	}
+	if rule.MaxRebuys != UNLIMITED_REBUYS && getBuyInStats(room.RoomId, apply.UserId, 0).Accepted > rule.MaxRebuys {
+		return false
+	}
+	return true
+}
+
+// 满足规则的申请直接通过，失败时保留为待审批，不影响申请本身
+func tryAutoApprove(room *RoomInfo, apply *records.ApplyScore) *records.ApplyScore {
+	if !matchAutoApproveRule(room, apply) {
+		return apply
+	}
+	accepted, err := user.AutoAcceptApply(apply.Id)
+	if err != nil {
+		logs.Error(nil, "auto approve failed: "+err.Error())
+		return apply
+	}
+	auditConfirm(room.RoomId, audit.ACTOR_SYSTEM, apply, accepted)
+	publish(room, event.EVENT_CONFIRM, accepted.UserId, accepted)
+	return accepted
+}
+
+// 房主设置自动审批规则
+func UpdateAutoApprove(roomId, operator int, rule AutoApproveRule) (*RoomInfo, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_UPDATE_ROOM); err != nil {
+		return nil, err
+	}
+	if err := checkAutoApproveRule(&rule); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(&rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := view.UpdateRoomAutoApprove(roomId, room.Owner, data); err != nil {
+		return nil, err
+	}
+
+	auditSetting(roomId, operator, "auto_approve", room.AutoApprove, rule)
+	room.AutoApprove = rule
+	publish(room, event.EVENT_UPDATE_ROOM, operator, nil)
+	return room.snapshot(), nil
+}
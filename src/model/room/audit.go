@@ -0,0 +1,87 @@
+package room
+
+import (
+	"errors"
+
+	"github.com/jianshao/poker_counter/src/model/audit"
+	"github.com/jianshao/poker_counter/src/model/records"
+	"github.com/jianshao/poker_counter/src/model/user"
+)
+
+func roomState(room *RoomInfo) *audit.RoomState {
+	return &audit.RoomState{
+		Status: room.Status,
+		Code:   room.Code,
+	}
+}
+
+// 玩家在房间内的状态，没有进入过房间时为空
+func playerState(roomId, userId int) *audit.PlayerState {
+	player := user.GetUser(userId)
+	if player == nil {
+		return nil
+	}
+	info, ok := player.Rooms[roomId]
+	if !ok {
+		return nil
+	}
+	return &audit.PlayerState{
+		InRoom: player.CurrRoomId == roomId,
+		Status: info.Status,
+		Role:   info.Role,
+	}
+}
+
+func applyState(apply *records.ApplyScore) *audit.ApplyState {
+	return &audit.ApplyState{
+		UserId:    apply.UserId,
+		Status:    apply.Status,
+		ApplyType: apply.ApplyType,
+		Score:     apply.Score,
+		RefId:     apply.RefId,
+		Reason:    apply.Reason,
+	}
+}
+
+// 记录玩家自己的操作，before为操作前的状态
+func auditPlayer(roomId, userId int, action string, before *audit.PlayerState) {
+	auditPlayerBy(roomId, userId, userId, action, before)
+}
+
+// 记录其他人对玩家的操作，例如授予角色
+func auditPlayerBy(roomId, actor, userId int, action string, before *audit.PlayerState) {
+	audit.Record(roomId, actor, action, audit.TARGET_USER, userId, before, playerState(roomId, userId))
+}
+
+// 记录房间设置的修改，name为设置项
+func auditSetting(roomId, operator int, name string, before, after interface{}) {
+	audit.Record(roomId, operator, audit.ACTION_UPDATE_ROOM, audit.TARGET_ROOM, roomId,
+		map[string]interface{}{name: before}, map[string]interface{}{name: after})
+}
+
+// 记录申请的审批，actor为0时表示按自动审批规则通过
+func auditConfirm(roomId, actor int, before, after *records.ApplyScore) {
+	auditApply(roomId, actor, audit.ACTION_CONFIRM_APPLY, before, after)
+}
+
+// 记录积分记录的变化，新增的记录before为空
+func auditApply(roomId, actor int, action string, before, after *records.ApplyScore) {
+	var beforeState interface{}
+	if before != nil {
+		beforeState = applyState(before)
+	}
+	audit.Record(roomId, actor, action, audit.TARGET_APPLY, after.Id, beforeState, applyState(after))
+}
+
+// 房主查看房间的审计日志，房间关闭后仍可查看
+func GetAuditEvents(roomId, userId, page, pageSize int) ([]audit.Event, error) {
+	defer lockRoom(roomId)()
+	room := getRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if room.Owner != userId {
+		return nil, ErrPermissionDenied
+	}
+	return audit.GetRoomEvents(roomId, page, pageSize)
+}
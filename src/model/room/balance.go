@@ -0,0 +1,137 @@
+package room
+
+import (
+	"errors"
+
+	"github.com/jianshao/poker_counter/src/model/audit"
+	"github.com/jianshao/poker_counter/src/model/event"
+	"github.com/jianshao/poker_counter/src/model/records"
+	"github.com/jianshao/poker_counter/src/model/user"
+)
+
+// 积分不平时的处理方式
+const (
+	BALANCE_MODE_PROPORTIONAL = 0 // 按赢分比例分摊给赢家
+	BALANCE_MODE_PLAYER       = 1 // 全部记在指定玩家名下
+	BALANCE_MODE_HOUSE        = 2 // 记在房间名下
+)
+
+// 关闭房间前检查买入和结算是否相等
+func CheckBalance(roomId, userId int) (*records.Balance, error) {
+	if CheckRoom(roomId) == nil {
+		return nil, errors.New("room not exist")
+	}
+	if !IsMember(roomId, userId) {
+		return nil, ErrPermissionDenied
+	}
+	return records.GetRoomBalance(roomId)
+}
+
+// 按赢分比例拆分差额，除不尽的部分记在赢分最多的玩家名下
+func splitProportional(room *RoomInfo, amount int) (map[int]int, error) {
+	winners := map[int]int{}
+	total, topWinner := 0, 0
+	for _, playerId := range room.Players {
+		player := user.GetUser(playerId)
+		if player == nil {
+			continue
+		}
+		info, ok := player.Rooms[room.RoomId]
+		if !ok {
+			continue
+		}
+		net := info.FinalScore - info.CurrScore
+		if net <= 0 {
+			continue
+		}
+		winners[playerId] = net
+		total += net
+		if topWinner == 0 || net > winners[topWinner] {
+			topWinner = playerId
+		}
+	}
+	if total == 0 {
+		return nil, errors.New("房间内没有赢家，无法按比例分摊")
+	}
+
+	shares := map[int]int{}
+	remain := amount
+	for playerId, net := range winners {
+		share := amount * net / total
+		shares[playerId] = share
+		remain -= share
+	}
+	shares[topWinner] += remain
+	return shares, nil
+}
+
+// 房主或庄家处理积分差额，生成的平账记录之和与差额相抵
+func ResolveBalance(roomId, operator, mode, playerId int) ([]records.ApplyScore, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_RESOLVE_BALANCE); err != nil {
+		return nil, err
+	}
+
+	balance, err := records.GetRoomBalance(roomId)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Diff == 0 {
+		return nil, errors.New("房间积分已平衡")
+	}
+
+	amount := -balance.Diff
+	applies := []records.ApplyScore{}
+	switch mode {
+	case BALANCE_MODE_PROPORTIONAL:
+		shares, err := splitProportional(room, amount)
+		if err != nil {
+			return nil, err
+		}
+		for userId, share := range shares {
+			if share == 0 {
+				delete(shares, userId)
+			}
+		}
+		// 所有平账记录在同一个事务中写入，不会只写入一部分
+		applies, err = user.AddBalances(roomId, shares)
+		if err != nil {
+			if len(applies) == 0 {
+				return nil, err
+			}
+			publishBalance(room, operator, applies)
+			return applies, err
+		}
+	case BALANCE_MODE_PLAYER:
+		if _, ok := room.Players[playerId]; !ok {
+			return nil, errors.New("user not in this room")
+		}
+		apply, err := user.AddBalance(roomId, playerId, amount)
+		if err != nil {
+			return nil, err
+		}
+		applies = append(applies, *apply)
+	case BALANCE_MODE_HOUSE:
+		apply, err := records.AddBalance(roomId, 0, amount)
+		if err != nil {
+			return nil, err
+		}
+		applies = append(applies, *apply)
+	default:
+		return nil, errors.New("balance mode error")
+	}
+	publishBalance(room, operator, applies)
+	return applies, nil
+}
+
+// 平账记录已经写入，逐条记录审计日志并通知订阅者
+func publishBalance(room *RoomInfo, operator int, applies []records.ApplyScore) {
+	for i := range applies {
+		auditApply(room.RoomId, operator, audit.ACTION_BALANCE, nil, &applies[i])
+		publish(room, event.EVENT_BALANCE, applies[i].UserId, &applies[i])
+	}
+}
@@ -0,0 +1,202 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jianshao/poker_counter/src/model/audit"
+	"github.com/jianshao/poker_counter/src/model/event"
+	"github.com/jianshao/poker_counter/src/model/records"
+	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+const (
+	MAX_COMMENT_LENGTH = 200
+)
+
+var (
+	ErrNeedPlayerAck     = errors.New("代录的结算需要玩家确认")
+	ErrDisputeUnresolved = errors.New("仍有争议的结算未处理，房间不可关闭")
+	// 代录的结算玩家不能撤回或修改
+	ErrProxyCashOutLocked = errors.New("代录的结算只能确认或提出争议")
+)
+
+// 等待玩家确认的代录结算
+func isProxyCashOut(apply *records.ApplyScore) bool {
+	return records.IsPendingCashOut(apply) && apply.CreatedBy != 0
+}
+
+// 结算的另一方：代录的结算由玩家确认，玩家提交的结算由有审批权限的人确认
+func checkCounterparty(room *RoomInfo, apply *records.ApplyScore, userId int) error {
+	if apply.CreatedBy != 0 {
+		if apply.UserId != userId {
+			return ErrPermissionDenied
+		}
+		return nil
+	}
+	return checkPermission(room, userId, PERM_CONFIRM_APPLY)
+}
+
+// 结算的双方和有审批权限的人可以查看和留言
+func checkCommentAccess(room *RoomInfo, apply *records.ApplyScore, userId int) error {
+	if apply.UserId == userId || apply.CreatedBy == userId {
+		return nil
+	}
+	return checkPermission(room, userId, PERM_CONFIRM_APPLY)
+}
+
+// 房主开启或关闭双向确认结算，只影响之后代录的结算
+func UpdateTwoSidedCashOut(roomId, operator int, enabled bool) (*RoomInfo, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_UPDATE_ROOM); err != nil {
+		return nil, err
+	}
+
+	if err := view.UpdateRoomTwoSidedCashOut(roomId, room.Owner, enabled); err != nil {
+		return nil, err
+	}
+
+	auditSetting(roomId, operator, "two_sided_cashout", room.TwoSidedCashOut, enabled)
+	room.TwoSidedCashOut = enabled
+	publish(room, event.EVENT_UPDATE_ROOM, operator, nil)
+	return room.snapshot(), nil
+}
+
+// 玩家确认房主代录的结算，确认后计入玩家的结算积分
+func AcknowledgeCashOut(roomId, userId, applyId int) (*records.ApplyScore, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	origin, err := getRoomApply(roomId, applyId)
+	if err != nil {
+		return nil, err
+	}
+	if !isProxyCashOut(origin) {
+		return nil, errors.New("只能确认代录的结算")
+	}
+	if origin.UserId != userId {
+		return nil, ErrPermissionDenied
+	}
+
+	apply, err := user.ConfirmBuyIn(applyId, records.APPLY_STATUS_ACCEPT)
+	if err != nil {
+		return nil, err
+	}
+	auditConfirm(roomId, userId, origin, apply)
+	publish(room, event.EVENT_ACKNOWLEDGE, userId, apply)
+	return apply, nil
+}
+
+// 结算的另一方对积分有异议，可以附带一条留言
+func DisputeCashOut(roomId, userId, applyId int, content string) (*records.ApplyScore, error) {
+	content = strings.TrimSpace(content)
+	if utf8.RuneCountInString(content) > MAX_COMMENT_LENGTH {
+		return nil, errors.New(fmt.Sprintf("留言不能超过%d个字", MAX_COMMENT_LENGTH))
+	}
+
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if !room.TwoSidedCashOut {
+		return nil, errors.New("房间未开启双向确认结算")
+	}
+	origin, err := getRoomApply(roomId, applyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCounterparty(room, origin, userId); err != nil {
+		return nil, err
+	}
+
+	apply, err := user.DisputeApply(applyId, userId, content)
+	if err != nil {
+		return nil, err
+	}
+	auditApply(roomId, userId, audit.ACTION_DISPUTE, origin, apply)
+	publish(room, event.EVENT_DISPUTE, userId, apply)
+	return apply, nil
+}
+
+// 房主处理有争议的结算：按协商后的积分同意，或者拒绝后由玩家重新提交，拒绝时保留原积分
+func ResolveDispute(roomId, operator, applyId, status, score int) (*records.ApplyScore, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_CONFIRM_APPLY); err != nil {
+		return nil, err
+	}
+	origin, err := getRoomApply(roomId, applyId)
+	if err != nil {
+		return nil, err
+	}
+
+	apply, err := user.ResolveDispute(applyId, status, score)
+	if err != nil {
+		return nil, err
+	}
+	auditApply(roomId, operator, audit.ACTION_RESOLVE, origin, apply)
+	publish(room, event.EVENT_RESOLVE, apply.UserId, apply)
+	return apply, nil
+}
+
+// 在积分记录下留言
+func AddComment(roomId, userId, applyId int, content string) (*records.Comment, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, errors.New("请填写留言内容")
+	}
+	if utf8.RuneCountInString(content) > MAX_COMMENT_LENGTH {
+		return nil, errors.New(fmt.Sprintf("留言不能超过%d个字", MAX_COMMENT_LENGTH))
+	}
+
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	apply, err := getRoomApply(roomId, applyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCommentAccess(room, apply, userId); err != nil {
+		return nil, err
+	}
+
+	comment, err := records.AddComment(applyId, userId, content)
+	if err != nil {
+		return nil, err
+	}
+	audit.Record(roomId, userId, audit.ACTION_COMMENT, audit.TARGET_COMMENT, comment.Id, nil, comment)
+	publish(room, event.EVENT_COMMENT, userId, apply)
+	return comment, nil
+}
+
+// 查看积分记录下的留言，房间关闭后仍可查看
+func GetComments(roomId, userId, applyId int) ([]records.Comment, error) {
+	defer lockRoom(roomId)()
+	room := getRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	apply, err := getRoomApply(roomId, applyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCommentAccess(room, apply, userId); err != nil {
+		return nil, err
+	}
+	return records.GetComments(applyId)
+}
@@ -0,0 +1,104 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jianshao/poker_counter/prisma/db"
+	"github.com/jianshao/poker_counter/src/utils"
+	"github.com/jianshao/poker_counter/src/utils/logs"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+// 房间号分配：未关闭房间的房间号保存在redis集合中，抢占成功才能使用，房间关闭时释放
+const (
+	ROOM_CODE_SET_KEY     = "room_codes"
+	ROOM_CODE_COUNTER_KEY = "room_Id"
+
+	DEFAULT_ROOM_CODE_LENGTH = 4
+	MIN_ROOM_CODE_LENGTH     = 3
+	MAX_ROOM_CODE_LENGTH     = 8
+)
+
+// 房间号位数，可以通过环境变量ROOM_CODE_LENGTH配置
+func roomCodeLength() int {
+	length, err := strconv.Atoi(os.Getenv("ROOM_CODE_LENGTH"))
+	if err != nil || length < MIN_ROOM_CODE_LENGTH || length > MAX_ROOM_CODE_LENGTH {
+		return DEFAULT_ROOM_CODE_LENGTH
+	}
+	return length
+}
+
+// 指定位数的房间号范围，不以0开头
+func roomCodeRange() (int, int) {
+	start := 1
+	for i := 1; i < roomCodeLength(); i++ {
+		start *= 10
+	}
+	return start, start * 9
+}
+
+// 分配一个未被占用的房间号，从递增计数器的位置开始依次尝试
+func allocateRoomCode() (int, error) {
+	start, span := roomCodeRange()
+	for i := 0; i < span; i++ {
+		counter, err := utils.Inc(ROOM_CODE_COUNTER_KEY)
+		if err != nil {
+			return 0, err
+		}
+		code := start + counter%span
+		added, err := utils.SAdd(ROOM_CODE_SET_KEY, code)
+		if err != nil {
+			return 0, err
+		}
+		if added == 0 {
+			continue
+		}
+
+		// redis数据可能丢失过，再和数据库核对一次，被占用的房间号保持占用状态
+		if _, err := view.GetOpenRoomByCode(code); err == db.ErrNotFound {
+			return code, nil
+		} else if err != nil {
+			releaseRoomCode(code)
+			return 0, err
+		}
+	}
+	return 0, errors.New("没有可用的房间号")
+}
+
+func releaseRoomCode(code int) {
+	if code == 0 {
+		return
+	}
+	if err := utils.SRem(ROOM_CODE_SET_KEY, code); err != nil {
+		logs.Error(nil, fmt.Sprintf("release room code %d failed: %s", code, err.Error()))
+	}
+}
+
+// 根据数据库中未关闭的房间重建已占用的房间号，保证重启后不会分配重复的房间号
+func syncRoomCodes() error {
+	rooms, err := view.GetAllOpenRooms()
+	if err != nil {
+		return err
+	}
+	if len(rooms) == 0 {
+		return utils.Del(ROOM_CODE_SET_KEY)
+	}
+
+	codes := []interface{}{}
+	for _, room := range rooms {
+		codes = append(codes, room.Code)
+	}
+	// 先写入临时集合再替换，避免替换过程中出现空集合
+	// 上次同步中途失败可能留下临时集合，先清掉，避免把已释放的房间号带回来
+	tmpKey := ROOM_CODE_SET_KEY + ":sync"
+	if err := utils.Del(tmpKey); err != nil {
+		return err
+	}
+	if _, err := utils.SAdd(tmpKey, codes...); err != nil {
+		return err
+	}
+	return utils.Rename(tmpKey, ROOM_CODE_SET_KEY)
+}
@@ -4,18 +4,29 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"time"
+	"sync"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/jianshao/poker_counter/src/model/event"
+	"github.com/jianshao/poker_counter/src/model/records"
 	"github.com/jianshao/poker_counter/src/utils"
 	"github.com/jianshao/poker_counter/src/view"
 )
 
 type RoomInfo struct {
-	RoomId  int
-	Owner   int
-	Status  int
-	Players map[int]int
+	RoomId      int // 房间的唯一ID，即Room表的主键
+	Code        int // 加入房间用的短房间号，房间关闭后会被复用
+	Owner       int
+	Status      int
+	Players     map[int]int
+	Rate        ChipRate
+	AutoApprove AutoApproveRule
+	BuyIn       BuyInPolicy
+	// 申请超过该时间未审批自动过期，单位为秒，为0时不过期
+	ApplyTimeout int
+	// 双向确认结算，代录的结算需要玩家确认，有争议时不能关闭房间
+	TwoSidedCashOut bool
+	Version         int64 // 房间或其中玩家的状态每变化一次加1
 }
 
 const (
@@ -24,18 +35,66 @@ const (
 )
 
 var (
-	gRoomMap = map[int]*RoomInfo{}
+	gRoomMap  = map[int]*RoomInfo{}
+	gRoomLock sync.RWMutex
+	// 同一个房间的操作串行执行，修改房间及其玩家的数据前需要持有房间锁
+	gRoomLocks utils.KeyedMutex
 )
 
 func initRoom() error {
 	// 为服务初始化资源
+	gRoomLock.Lock()
 	gRoomMap = map[int]*RoomInfo{}
-	// 从redis获取房间号开始位置
-	return nil
+	gRoomLock.Unlock()
+	if err := cleanLegacyCache(); err != nil {
+		return err
+	}
+	// 从数据库同步已占用的房间号
+	return syncRoomCodes()
+}
+
+func Init() error {
+	return initRoom()
+}
+
+func lockRoom(roomId int) func() {
+	return gRoomLocks.Lock(roomId)
 }
 
+// 复制房间信息，返回给房间锁之外的调用方
+func (room *RoomInfo) snapshot() *RoomInfo {
+	if room == nil {
+		return nil
+	}
+	roomCopy := *room
+	roomCopy.Players = make(map[int]int, len(room.Players))
+	for playerId := range room.Players {
+		roomCopy.Players[playerId] = playerId
+	}
+	return &roomCopy
+}
+
+func getRoomFromMap(roomId int) (*RoomInfo, bool) {
+	gRoomLock.RLock()
+	defer gRoomLock.RUnlock()
+	room, ok := gRoomMap[roomId]
+	return room, ok
+}
+
+// 已经有其他协程载入了该房间时，使用已载入的数据
+func setRoom2Map(room *RoomInfo) *RoomInfo {
+	gRoomLock.Lock()
+	defer gRoomLock.Unlock()
+	if curr, ok := gRoomMap[room.RoomId]; ok {
+		return curr
+	}
+	gRoomMap[room.RoomId] = room
+	return room
+}
+
+// 返回缓存中的房间，修改时需要持有房间锁
 func getRoom(roomId int) *RoomInfo {
-	if roomInfo, ok := gRoomMap[roomId]; ok {
+	if roomInfo, ok := getRoomFromMap(roomId); ok {
 		return roomInfo
 	}
 	// TODO: 如果大量访问不存在的房间会导致资源浪费
@@ -54,6 +113,7 @@ func getActiveRoom(roomId int) *RoomInfo {
 }
 
 func IsOwner(roomId, userId int) bool {
+	defer lockRoom(roomId)()
 	roomInfo := getActiveRoom(roomId)
 	if roomInfo.Owner == userId {
 		return true
@@ -61,20 +121,10 @@ func IsOwner(roomId, userId int) bool {
 	return false
 }
 
-// 生成房间号，生成规则：以星期为周期，每周从1开始，每次生成房间号，房间号为星期*1000+递增的房间号
-func generateRoomId() int {
-	weekday := int(time.Now().Weekday()) + 1
-	roomId, err := utils.Inc("room_Id")
-	if err != nil {
-		return 0
-	}
-	return weekday*1000 + roomId%1000
-}
-
 // 载入房间信息
 func loadRoom(roomId int) (*RoomInfo, error) {
 	// 先从本地缓存获取
-	if room, ok := gRoomMap[roomId]; ok {
+	if room, ok := getRoomFromMap(roomId); ok {
 		return room, nil
 	}
 
@@ -85,8 +135,7 @@ func loadRoom(roomId int) (*RoomInfo, error) {
 			return nil, err
 		}
 	} else if room != nil {
-		gRoomMap[roomId] = room
-		return room, nil
+		return setRoom2Map(room), nil
 	}
 
 	// 从数据库中获取房间信息
@@ -95,7 +144,9 @@ func loadRoom(roomId int) (*RoomInfo, error) {
 		// 如果数据不存在会进入这个分支
 		return nil, err
 	} else if room.RoomId != 0 {
-		gRoomMap[roomId] = room
+		if curr := setRoom2Map(room); curr != room {
+			return curr, nil
+		}
 		setRoom2Redis(room, 0)
 	} else {
 		return nil, errors.New("room not exist")
@@ -104,20 +155,38 @@ func loadRoom(roomId int) (*RoomInfo, error) {
 }
 
 func loadRoomFromData(roomId int) (*RoomInfo, error) {
-	room, err := view.GetRoomByRoomId(roomId, 0)
+	room, err := view.GetRoomById(roomId)
 	if err != nil {
 		return nil, err
 	}
 	return &RoomInfo{
-		RoomId:  room.RoomID,
+		RoomId:  room.ID,
+		Code:    room.Code,
 		Owner:   room.Owner,
 		Status:  view.RoomStatus2int[room.Status],
 		Players: map[int]int{},
+		Rate: ChipRate{
+			Chips:    room.Chips,
+			Money:    room.Money,
+			Currency: room.Currency,
+			Scale:    int32(room.MoneyScale),
+			Rounding: view.Rounding2int[room.Rounding],
+		},
+		AutoApprove: parseAutoApproveRule(room.AutoApprove),
+		BuyIn: BuyInPolicy{
+			MinBuyIn:      room.MinBuyIn,
+			MaxBuyIn:      room.MaxBuyIn,
+			MaxTotalBuyIn: room.MaxTotalBuyIn,
+			MaxRebuys:     room.MaxRebuys,
+			RebuyCooldown: room.RebuyCooldown,
+		},
+		ApplyTimeout:    room.ApplyTimeout,
+		TwoSidedCashOut: room.TwoSidedCashout,
 	}, nil
 }
 
 func buildRoomKey(roomId int) string {
-	return fmt.Sprintf("room:%d", roomId)
+	return fmt.Sprintf("Room:%d", roomId)
 }
 
 // 从redis载入房间信息
@@ -129,7 +198,8 @@ func loadRoomFromRedis(roomId int) (*RoomInfo, error) {
 		return nil, err
 	}
 
-	var room RoomInfo
+	// 缓存中没有的字段使用默认值
+	room := RoomInfo{BuyIn: defaultBuyInPolicy(), ApplyTimeout: DEFAULT_APPLY_TIMEOUT}
 	err = json.Unmarshal([]byte(roomInfo), &room)
 	if err != nil {
 		return nil, err
@@ -146,6 +216,31 @@ func setRoom2Redis(room *RoomInfo, timeout int) error {
 	return utils.SetString(key, string(roomStr), timeout)
 }
 
+// 房间或其中玩家的状态发生了变化：增加版本号并保存房间，再通知订阅者
+// 申请保存快照，避免后续修改影响已发出的事件
+func publish(room *RoomInfo, eventType string, userId int, apply *records.ApplyScore) {
+	bumpVersion(room)
+	timeout := 0
+	if room.Status == RoomStatus_Close {
+		// 设置过期时间,防止长时间占用
+		timeout = 24 * 3600
+	}
+	setRoom2Redis(room, timeout)
+
+	var snapshot *records.ApplyScore
+	if apply != nil {
+		applyCopy := *apply
+		snapshot = &applyCopy
+	}
+	event.Publish(&event.Event{
+		RoomId:  room.RoomId,
+		Version: room.Version,
+		Type:    eventType,
+		UserId:  userId,
+		Apply:   snapshot,
+	})
+}
+
 func delRoomFromRedis(roomId int) error {
 	return utils.Del(buildRoomKey(roomId))
 }
@@ -0,0 +1,89 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jianshao/poker_counter/src/model/audit"
+	"github.com/jianshao/poker_counter/src/model/event"
+	"github.com/jianshao/poker_counter/src/model/records"
+	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/utils/logs"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+const (
+	// 默认不过期，由房主按需开启
+	DEFAULT_APPLY_TIMEOUT = 0
+	// 过期检查的执行间隔，单位为秒
+	EXPIRE_CHECK_INTERVAL = 60
+)
+
+// 定时任务：超时未审批的申请过期，并通知申请的玩家
+func ExpireStaleApplies() error {
+	applies, err := records.GetExpiredApplies()
+	if err != nil {
+		return err
+	}
+
+	roomApplies := map[int][]records.ApplyScore{}
+	for _, apply := range applies {
+		roomApplies[apply.RoomId] = append(roomApplies[apply.RoomId], apply)
+	}
+	expired := 0
+	for roomId, applies := range roomApplies {
+		expired += expireRoomApplies(roomId, applies)
+	}
+	if expired > 0 {
+		logs.Info(nil, fmt.Sprintf("expire %d applies in %d rooms", expired, len(roomApplies)))
+	}
+	return nil
+}
+
+// 同一个房间内的申请持有房间锁逐个过期，已被审批或撤回的申请跳过
+func expireRoomApplies(roomId int, applies []records.ApplyScore) int {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return 0
+	}
+
+	expired := 0
+	for _, apply := range applies {
+		result, err := user.ExpireApply(roomId, apply.UserId, apply.Id)
+		if err != nil {
+			if !errors.Is(err, records.ErrApplyProcessed) {
+				logs.Error(nil, fmt.Sprintf("expire apply %d failed: %s", apply.Id, err.Error()))
+			}
+			continue
+		}
+		auditApply(roomId, audit.ACTOR_SYSTEM, audit.ACTION_EXPIRE_APPLY, &apply, result)
+		publish(room, event.EVENT_EXPIRE, result.UserId, result)
+		expired += 1
+	}
+	return expired
+}
+
+// 修改申请的超时时间，为0时不过期
+func UpdateApplyTimeout(roomId, operator, timeout int) (*RoomInfo, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_UPDATE_ROOM); err != nil {
+		return nil, err
+	}
+	if timeout < 0 {
+		return nil, errors.New("超时时间不能为负数")
+	}
+
+	if err := view.UpdateRoomApplyTimeout(roomId, room.Owner, timeout); err != nil {
+		return nil, err
+	}
+
+	auditSetting(roomId, operator, "apply_timeout", room.ApplyTimeout, timeout)
+	room.ApplyTimeout = timeout
+	publish(room, event.EVENT_UPDATE_ROOM, operator, nil)
+	return room.snapshot(), nil
+}
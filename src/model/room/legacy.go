@@ -0,0 +1,41 @@
+package room
+
+import (
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/utils"
+	"github.com/jianshao/poker_counter/src/utils/logs"
+)
+
+const (
+	// 之前的房间缓存以房间号为key，格式为room:<房间号>
+	LEGACY_ROOM_KEY_PATTERN = "room:*"
+	// 旧缓存清理完成的标记，只需要清理一次
+	LEGACY_CACHE_CLEANED_KEY = "legacy_room_cache_cleaned"
+)
+
+// 房间引用从房间号改为Room.id之前写入的缓存：房间缓存以房间号为key，用户缓存中的房间也以房间号索引。
+// 房间号会被复用，无法从缓存本身判断对应的房间，直接删除后从database重建。
+// 需要先执行prisma/sql/room_id_to_room_pk.sql迁移database中的数据。
+func cleanLegacyCache() error {
+	cleaned, err := utils.GetString(LEGACY_CACHE_CLEANED_KEY)
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	if cleaned != "" {
+		return nil
+	}
+
+	rooms, err := utils.DelKeys(LEGACY_ROOM_KEY_PATTERN)
+	if err != nil {
+		return err
+	}
+	users, err := user.ClearCache()
+	if err != nil {
+		return err
+	}
+	logs.Info(nil, fmt.Sprintf("clean legacy cache: %d rooms, %d users", rooms, users))
+	return utils.SetString(LEGACY_CACHE_CLEANED_KEY, "1", 0)
+}
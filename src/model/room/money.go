@@ -0,0 +1,119 @@
+package room
+
+import (
+	"errors"
+
+	"github.com/jianshao/poker_counter/src/model/event"
+	"github.com/jianshao/poker_counter/src/view"
+	"github.com/shopspring/decimal"
+)
+
+// 金额舍入方式
+const (
+	ROUNDING_HALF_UP   = 0 // 四舍五入
+	ROUNDING_HALF_EVEN = 1 // 银行家舍入
+	ROUNDING_DOWN      = 2 // 向零截断
+	ROUNDING_UP        = 3 // 远离零进位
+)
+
+// 积分与金额的兑换比例：Chips积分对应Money金额
+type ChipRate struct {
+	Chips    int
+	Money    decimal.Decimal
+	Currency string
+	Scale    int32 // 保留的小数位数
+	Rounding int
+}
+
+func defaultChipRate() ChipRate {
+	return ChipRate{
+		Chips:    1,
+		Money:    decimal.NewFromInt(1),
+		Currency: "CNY",
+		Scale:    2,
+		Rounding: ROUNDING_HALF_UP,
+	}
+}
+
+func (rate *ChipRate) round(value decimal.Decimal) decimal.Decimal {
+	switch rate.Rounding {
+	case ROUNDING_HALF_EVEN:
+		return value.RoundBank(rate.Scale)
+	case ROUNDING_DOWN:
+		return value.RoundDown(rate.Scale)
+	case ROUNDING_UP:
+		return value.RoundUp(rate.Scale)
+	default:
+		return value.Round(rate.Scale)
+	}
+}
+
+// 将积分换算为金额
+func (rate *ChipRate) ToMoney(chips int) decimal.Decimal {
+	if rate.Chips <= 0 {
+		return decimal.Zero
+	}
+	value := decimal.NewFromInt(int64(chips)).Mul(rate.Money).Div(decimal.NewFromInt(int64(rate.Chips)))
+	return rate.round(value)
+}
+
+// 按保留位数格式化金额，避免浮点误差
+func (rate *ChipRate) Format(chips int) string {
+	return rate.ToMoney(chips).StringFixed(rate.Scale)
+}
+
+// 获取房间的兑换比例，房间不存在时按1:1换算
+func GetChipRate(roomId int) *ChipRate {
+	defer lockRoom(roomId)()
+	room := getRoom(roomId)
+	if room == nil || room.Rate.Chips <= 0 {
+		rate := defaultChipRate()
+		return &rate
+	}
+	rate := room.Rate
+	return &rate
+}
+
+func checkChipRate(rate *ChipRate) error {
+	if rate.Chips <= 0 {
+		return errors.New("积分数量必须大于0")
+	}
+	if rate.Money.IsNegative() {
+		return errors.New("金额不能为负数")
+	}
+	if rate.Scale < 0 || rate.Scale > 8 {
+		return errors.New("小数位数需要在0-8之间")
+	}
+	if rate.Rounding < ROUNDING_HALF_UP || rate.Rounding > ROUNDING_UP {
+		return errors.New("舍入方式不正确")
+	}
+	if rate.Currency == "" {
+		rate.Currency = "CNY"
+	}
+	return nil
+}
+
+// 设置积分与金额的兑换比例
+func UpdateChipRate(roomId, operator int, rate ChipRate) (*RoomInfo, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_UPDATE_ROOM); err != nil {
+		return nil, err
+	}
+	if err := checkChipRate(&rate); err != nil {
+		return nil, err
+	}
+
+	err := view.UpdateRoomRate(roomId, room.Owner, rate.Chips, rate.Money, rate.Currency, int(rate.Scale), rate.Rounding)
+	if err != nil {
+		return nil, err
+	}
+
+	auditSetting(roomId, operator, "rate", room.Rate, rate)
+	room.Rate = rate
+	publish(room, event.EVENT_UPDATE_ROOM, operator, nil)
+	return room.snapshot(), nil
+}
@@ -0,0 +1,139 @@
+package room
+
+import (
+	"errors"
+
+	"github.com/jianshao/poker_counter/src/model/audit"
+	"github.com/jianshao/poker_counter/src/model/event"
+	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+// 房间内的操作权限
+const (
+	PERM_PLAY            = iota // 参与游戏、申请买入和结算
+	PERM_CONFIRM_APPLY          // 审批买入和结算申请
+	PERM_RESOLVE_BALANCE        // 处理积分差额
+	PERM_UPDATE_ROOM            // 修改房间设置
+	PERM_MANAGE_ROLE            // 授予和收回角色
+	PERM_CLOSE_ROOM             // 关闭房间
+	PERM_REVERSE_RECORD         // 冲正已确认的记录
+	PERM_ADJUST_SCORE           // 手动调整玩家积分
+	PERM_PROXY_RECORD           // 代玩家录入买入和结算
+)
+
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// 副房主可以审批申请，庄家负责收付筹码，观众只能查看
+	rolePermissions = map[int]map[int]bool{
+		user.USER_ROLE_OWNER: {
+			PERM_PLAY:            true,
+			PERM_CONFIRM_APPLY:   true,
+			PERM_RESOLVE_BALANCE: true,
+			PERM_UPDATE_ROOM:     true,
+			PERM_MANAGE_ROLE:     true,
+			PERM_CLOSE_ROOM:      true,
+			PERM_REVERSE_RECORD:  true,
+			PERM_ADJUST_SCORE:    true,
+			PERM_PROXY_RECORD:    true,
+		},
+		user.USER_ROLE_COHOST: {
+			PERM_PLAY:          true,
+			PERM_CONFIRM_APPLY: true,
+			PERM_ADJUST_SCORE:  true,
+			PERM_PROXY_RECORD:  true,
+		},
+		user.USER_ROLE_BANKER: {
+			PERM_PLAY:            true,
+			PERM_CONFIRM_APPLY:   true,
+			PERM_RESOLVE_BALANCE: true,
+			PERM_PROXY_RECORD:    true,
+		},
+		user.USER_ROLE_PLAYER: {
+			PERM_PLAY: true,
+		},
+		user.USER_ROLE_SPECTATOR: {},
+	}
+)
+
+// 获取用户在房间内的角色，房主不需要进入房间
+// 关闭的房间不再缓存玩家，从数据库中的玩家记录获取
+func getRole(room *RoomInfo, userId int) (int, bool) {
+	if room.Owner == userId {
+		return user.USER_ROLE_OWNER, true
+	}
+	if room.Status != RoomStatus_Open {
+		player, err := view.GetRoomPlayer(room.RoomId, userId)
+		if err != nil {
+			return 0, false
+		}
+		return view.Role2int[player.Role], true
+	}
+	return user.GetRoomRole(room.RoomId, userId)
+}
+
+func checkPermission(room *RoomInfo, userId, perm int) error {
+	role, ok := getRole(room, userId)
+	if !ok || !rolePermissions[role][perm] {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// 房主和进入过房间的用户可以查看房间动态
+func IsMember(roomId, userId int) bool {
+	defer lockRoom(roomId)()
+	room := getRoom(roomId)
+	if room == nil {
+		return false
+	}
+	_, ok := getRole(room, userId)
+	return ok
+}
+
+func HasPermission(roomId, userId, perm int) bool {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return false
+	}
+	return checkPermission(room, userId, perm) == nil
+}
+
+// 授予角色，房主不能被修改，也不能授予房主
+func GrantRole(roomId, operator, userId, role int) error {
+	return setRole(roomId, operator, userId, role, audit.ACTION_GRANT_ROLE)
+}
+
+// 收回角色，恢复为普通玩家
+func RevokeRole(roomId, operator, userId int) error {
+	return setRole(roomId, operator, userId, user.USER_ROLE_PLAYER, audit.ACTION_REVOKE_ROLE)
+}
+
+func setRole(roomId, operator, userId, role int, action string) error {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_MANAGE_ROLE); err != nil {
+		return err
+	}
+	if userId == room.Owner || role == user.USER_ROLE_OWNER {
+		return errors.New("can not change room owner")
+	}
+	if _, ok := rolePermissions[role]; !ok {
+		return errors.New("role error")
+	}
+	if _, ok := room.Players[userId]; !ok {
+		return errors.New("user not in this room")
+	}
+	before := playerState(roomId, userId)
+	if err := user.SetRoomRole(roomId, userId, role); err != nil {
+		return err
+	}
+	auditPlayerBy(roomId, operator, userId, action, before)
+	publish(room, event.EVENT_ROLE, userId, nil)
+	return nil
+}
@@ -0,0 +1,160 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jianshao/poker_counter/src/model/event"
+	"github.com/jianshao/poker_counter/src/model/records"
+	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+const (
+	UNLIMITED_REBUYS = -1
+)
+
+var (
+	ErrBuyInTooSmall      = errors.New("低于单次最低买入")
+	ErrBuyInTooLarge      = errors.New("超过单次最高买入")
+	ErrBuyInTotalExceeded = errors.New("超过累计买入上限")
+	ErrRebuyLimit         = errors.New("补码次数已用完")
+	ErrRebuyCooldown      = errors.New("补码间隔时间未到")
+)
+
+// 房间的买入限制，积分限制为0时不限制
+type BuyInPolicy struct {
+	MinBuyIn      int // 单次最低买入
+	MaxBuyIn      int // 单次最高买入
+	MaxTotalBuyIn int // 每个玩家累计买入上限，包括待审批的申请
+	MaxRebuys     int // 首次买入之后的补码次数，-1为不限制
+	RebuyCooldown int // 两次买入之间的间隔，单位为秒
+}
+
+func defaultBuyInPolicy() BuyInPolicy {
+	return BuyInPolicy{
+		MaxRebuys: UNLIMITED_REBUYS,
+	}
+}
+
+// 玩家在房间内已通过和待审批的买入，冲正记录只计入积分
+type buyInStats struct {
+	Accepted  int // 已通过的买入次数
+	Pending   int // 待审批的买入次数
+	Total     int // 已通过和待审批的买入积分之和
+	LastApply time.Time
+}
+
+func getBuyInStats(roomId, userId, excludeApplyId int) buyInStats {
+	stats := buyInStats{}
+	player := user.GetUser(userId)
+	if player == nil {
+		return stats
+	}
+	info, ok := player.Rooms[roomId]
+	if !ok {
+		return stats
+	}
+	for applyId := range info.ApplyList {
+		if applyId == excludeApplyId {
+			continue
+		}
+		apply, err := records.GetApply(applyId)
+		if err != nil || apply.ApplyType != records.APPLY_TYPE_BUYIN {
+			continue
+		}
+		if apply.Status != records.APPLY_STATUS_ACCEPT && apply.Status != records.APPLY_STATUS_APPLY {
+			continue
+		}
+		stats.Total += apply.Score
+		if apply.RefId != 0 {
+			continue
+		}
+		if apply.Status == records.APPLY_STATUS_ACCEPT {
+			stats.Accepted += 1
+		} else {
+			stats.Pending += 1
+		}
+		if apply.ApplyAt.After(stats.LastApply) {
+			stats.LastApply = apply.ApplyAt
+		}
+	}
+	return stats
+}
+
+// 检查玩家的买入是否符合房间的买入限制，调用方需要持有房间锁
+// 修改申请时excludeApplyId为被修改的申请，不计入已有的买入
+func checkBuyInPolicy(room *RoomInfo, userId, score, excludeApplyId int) error {
+	policy := &room.BuyIn
+	if policy.MinBuyIn > 0 && score < policy.MinBuyIn {
+		return fmt.Errorf("%w: %d", ErrBuyInTooSmall, policy.MinBuyIn)
+	}
+	if policy.MaxBuyIn > 0 && score > policy.MaxBuyIn {
+		return fmt.Errorf("%w: %d", ErrBuyInTooLarge, policy.MaxBuyIn)
+	}
+
+	stats := getBuyInStats(room.RoomId, userId, excludeApplyId)
+	if policy.MaxTotalBuyIn > 0 && stats.Total+score > policy.MaxTotalBuyIn {
+		return fmt.Errorf("%w: %d", ErrBuyInTotalExceeded, policy.MaxTotalBuyIn)
+	}
+	count := stats.Accepted + stats.Pending
+	if count == 0 {
+		return nil
+	}
+	if policy.MaxRebuys != UNLIMITED_REBUYS && count > policy.MaxRebuys {
+		return fmt.Errorf("%w: %d", ErrRebuyLimit, policy.MaxRebuys)
+	}
+	if policy.RebuyCooldown > 0 {
+		wait := time.Until(stats.LastApply.Add(time.Duration(policy.RebuyCooldown) * time.Second))
+		if wait > 0 {
+			return fmt.Errorf("%w: %d", ErrRebuyCooldown, int(wait.Seconds())+1)
+		}
+	}
+	return nil
+}
+
+func checkPolicy(policy *BuyInPolicy) error {
+	if policy.MinBuyIn < 0 || policy.MaxBuyIn < 0 || policy.MaxTotalBuyIn < 0 {
+		return errors.New("买入限制不能为负数")
+	}
+	if policy.MaxBuyIn > 0 && policy.MinBuyIn > policy.MaxBuyIn {
+		return errors.New("最低买入不能高于最高买入")
+	}
+	if policy.MaxTotalBuyIn > 0 && policy.MinBuyIn > policy.MaxTotalBuyIn {
+		return errors.New("最低买入不能高于累计买入上限")
+	}
+	if policy.MaxRebuys < UNLIMITED_REBUYS {
+		return errors.New("补码次数错误")
+	}
+	if policy.RebuyCooldown < 0 {
+		return errors.New("补码间隔不能为负数")
+	}
+	return nil
+}
+
+// 修改房间的买入限制，只影响之后的申请
+func UpdateBuyInPolicy(roomId, operator int, policy BuyInPolicy) (*RoomInfo, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_UPDATE_ROOM); err != nil {
+		return nil, err
+	}
+	if err := checkPolicy(&policy); err != nil {
+		return nil, err
+	}
+
+	err := view.UpdateRoomBuyInPolicy(roomId, room.Owner, policy.MinBuyIn, policy.MaxBuyIn,
+		policy.MaxTotalBuyIn, policy.MaxRebuys, policy.RebuyCooldown)
+	if err != nil {
+		return nil, err
+	}
+
+	auditSetting(roomId, operator, "buy_in", room.BuyIn, policy)
+	room.BuyIn = policy
+	publish(room, event.EVENT_UPDATE_ROOM, operator, nil)
+	return room.snapshot(), nil
+}
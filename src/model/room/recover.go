@@ -0,0 +1,76 @@
+package room
+
+import (
+	"fmt"
+
+	"github.com/jianshao/poker_counter/src/model/event"
+	"github.com/jianshao/poker_counter/src/model/records"
+	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/utils/logs"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+// 进程重启或redis数据丢失后，从database重建所有未关闭的房间
+func Recover() error {
+	rooms, err := view.GetAllOpenRooms()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, room := range rooms {
+		if err := RecoverRoom(room.ID); err != nil {
+			failed += 1
+			logs.Error(nil, fmt.Sprintf("recover room %d failed: %s", room.ID, err.Error()))
+		}
+	}
+	logs.Info(nil, fmt.Sprintf("recover %d rooms, %d failed", len(rooms), failed))
+	if failed > 0 {
+		return fmt.Errorf("recover %d of %d rooms failed", failed, len(rooms))
+	}
+	return nil
+}
+
+// 重放房间的积分记录，重建房间的玩家列表以及每个玩家的积分和申请列表
+func RecoverRoom(roomId int) error {
+	defer lockRoom(roomId)()
+	room, err := loadRoomFromData(roomId)
+	if err != nil {
+		return err
+	}
+
+	replays, err := records.ReplayRoom(roomId)
+	if err != nil {
+		return err
+	}
+
+	// 在房间内或参与过游戏的玩家，以及有过记录的玩家
+	roomPlayers, err := view.GetRoomPlayers(roomId)
+	if err != nil {
+		return err
+	}
+	for _, roomPlayer := range roomPlayers {
+		if roomPlayer.InRoom || roomPlayer.JoinTime != "" {
+			room.Players[roomPlayer.UID] = roomPlayer.UID
+		}
+	}
+	for userId := range replays {
+		room.Players[userId] = userId
+	}
+
+	for userId := range room.Players {
+		if err := user.RestoreRoom(roomId, userId, replays[userId]); err != nil {
+			return err
+		}
+	}
+
+	// 版本号延续之前的值，保证等待中的客户端能感知到变化
+	if prev, err := loadRoomFromRedis(roomId); err == nil {
+		room.Version = prev.Version
+	}
+	gRoomLock.Lock()
+	gRoomMap[roomId] = room
+	gRoomLock.Unlock()
+	publish(room, event.EVENT_RECOVER, 0, nil)
+	return nil
+}
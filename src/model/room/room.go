@@ -3,51 +3,97 @@ package room
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jianshao/poker_counter/prisma/db"
+	"github.com/jianshao/poker_counter/src/model/audit"
+	"github.com/jianshao/poker_counter/src/model/event"
 	"github.com/jianshao/poker_counter/src/model/records"
+	"github.com/jianshao/poker_counter/src/model/settlement"
 	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/utils"
 	"github.com/jianshao/poker_counter/src/view"
 )
 
 const (
 	INVALID_ROOM_ID = 0
+
+	// 手动调整原因的最大长度
+	MAX_ADJUST_REASON_LENGTH = 200
+	// 批量审批一次最多处理的申请数量
+	MAX_BATCH_CONFIRM = 100
+)
+
+// 批量审批中单个申请的处理结果
+type ConfirmResult struct {
+	ApplyId int
+	Apply   *records.ApplyScore
+	Err     error
+}
+
+var (
+	// 同一个用户的创建请求串行执行，避免同时创建出多个房间
+	gCreateLocks utils.KeyedMutex
 )
 
 // 1. owner create room
 func CreateRoom(userId int) (*RoomInfo, error) {
+	defer gCreateLocks.Lock(userId)()
 	// 用户在同一时间只能存在一个未关闭的房间
 	room, err := view.GetOpenRoom(userId)
 	if err == db.ErrNotFound {
 		// 在数据库中创建一个房间
-		roomId := generateRoomId()
-		if _, err := view.CreateOneRoom(roomId, userId); err != nil {
+		code, err := allocateRoomCode()
+		if err != nil {
+			return nil, err
+		}
+		newRoom, err := view.CreateOneRoom(code, userId)
+		if err != nil {
+			releaseRoomCode(code)
 			return nil, err
 		}
 
 		// 将房间信息载入进程
-		return loadRoom(roomId)
+		defer lockRoom(newRoom.ID)()
+		roomInfo, err := loadRoom(newRoom.ID)
+		if err != nil {
+			return nil, err
+		}
+		audit.Record(roomInfo.RoomId, userId, audit.ACTION_CREATE_ROOM, audit.TARGET_ROOM, roomInfo.RoomId, nil, roomState(roomInfo))
+		return roomInfo.snapshot(), nil
 	}
 
 	if err != nil {
 		return nil, err
 	}
-	return nil, errors.New(fmt.Sprintf("已经拥有房间：%d", room.RoomID))
+	return nil, errors.New(fmt.Sprintf("已经拥有房间：%d", room.Code))
 }
 
 func CheckRoom(roomId int) *RoomInfo {
-	return getActiveRoom(roomId)
+	defer lockRoom(roomId)()
+	return getActiveRoom(roomId).snapshot()
 }
 
-func CloseRoom(roomId, userId int) error {
+// 通过房间号查找未关闭的房间
+func CheckRoomByCode(code int) *RoomInfo {
+	room, err := view.GetOpenRoomByCode(code)
+	if err != nil {
+		return nil
+	}
+	return CheckRoom(room.ID)
+}
+
+func CloseRoom(roomId, userId int) (*settlement.Settlement, error) {
+	defer lockRoom(roomId)()
 	room := getActiveRoom(roomId)
 	if room == nil {
-		return nil
+		return nil, nil
 	}
 
-	if room.Owner != userId {
-		return errors.New("only room owner can close room")
+	if err := checkPermission(room, userId, PERM_CLOSE_ROOM); err != nil {
+		return nil, err
 	}
 
 	count := 0
@@ -57,19 +103,116 @@ func CloseRoom(roomId, userId int) error {
 		}
 	}
 	if count > 0 {
-		return errors.New(fmt.Sprintf("仍有%d个玩家没有提交剩余积分数量，房间不可关闭。", count))
+		return nil, errors.New(fmt.Sprintf("仍有%d个玩家没有提交剩余积分数量，房间不可关闭。", count))
+	}
+
+	// 买入和结算不相等时，需要房主先处理差额
+	balance, err := records.GetRoomBalance(roomId)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Diff != 0 {
+		return nil, errors.New(fmt.Sprintf("结算积分与买入积分相差%d，请先处理差额再关闭房间。", balance.Diff))
+	}
+
+	// 有争议的结算需要先处理
+	disputes, err := records.CountDisputes(roomId)
+	if err != nil {
+		return nil, err
+	}
+	if disputes > 0 {
+		return nil, fmt.Errorf("%w: %d", ErrDisputeUnresolved, disputes)
 	}
 
+	before := roomState(room)
 	room.Status = RoomStatus_Close
-	// 设置过期时间,防止长时间占用
-	setRoom2Redis(room, 24*3600)
 	// 更新数据库
-	view.CloseRoom(roomId, userId)
-	return nil
+	view.CloseRoom(roomId, room.Owner)
+	releaseRoomCode(room.Code)
+	audit.Record(roomId, userId, audit.ACTION_CLOSE_ROOM, audit.TARGET_ROOM, roomId, before, roomState(room))
+	publish(room, event.EVENT_CLOSE_ROOM, userId, nil)
+	return buildSettlement(room), nil
+}
+
+// 根据每个玩家已确认的买入和结算积分计算输赢及转账方案
+func buildSettlement(room *RoomInfo) *settlement.Settlement {
+	players := []settlement.PlayerResult{}
+	for _, playerId := range room.Players {
+		player := user.GetUser(playerId)
+		if player == nil {
+			continue
+		}
+		info, ok := player.Rooms[room.RoomId]
+		if !ok {
+			continue
+		}
+		players = append(players, settlement.PlayerResult{
+			UserId:  player.Id,
+			Name:    player.Name,
+			BuyIn:   info.CurrScore,
+			CashOut: info.FinalScore,
+		})
+	}
+	return settlement.NewSettlement(room.RoomId, addHouseResult(room.RoomId, players))
+}
+
+// 关闭的房间不再缓存玩家，从数据库中的玩家记录计算结算结果
+func buildClosedSettlement(room *RoomInfo) (*settlement.Settlement, error) {
+	roomPlayers, err := view.GetRoomPlayers(room.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	players := []settlement.PlayerResult{}
+	for _, roomPlayer := range roomPlayers {
+		// 只进入过房间没有参与游戏的用户不计入结算
+		if roomPlayer.JoinTime == "" && roomPlayer.CurrScore == 0 && roomPlayer.FinalScore == 0 {
+			continue
+		}
+		name := ""
+		if userInfo, err := view.GetUserById(roomPlayer.UID); err == nil {
+			name = userInfo.Name
+		}
+		players = append(players, settlement.PlayerResult{
+			UserId:  roomPlayer.UID,
+			Name:    name,
+			BuyIn:   roomPlayer.CurrScore,
+			CashOut: roomPlayer.FinalScore,
+		})
+	}
+	return settlement.NewSettlement(room.RoomId, addHouseResult(room.RoomId, players)), nil
+}
+
+// 记在房间名下的平账，由房间作为一方参与结算
+func addHouseResult(roomId int, players []settlement.PlayerResult) []settlement.PlayerResult {
+	if balance, err := records.GetRoomBalance(roomId); err == nil && balance.House != 0 {
+		players = append(players, settlement.PlayerResult{
+			UserId:  settlement.HOUSE_ID,
+			Name:    "房间",
+			CashOut: balance.House,
+		})
+	}
+	return players
+}
+
+// 房间关闭后仍然可以查看结算结果，只有房间成员可以查看
+func GetSettlement(roomId, userId int) (*settlement.Settlement, error) {
+	defer lockRoom(roomId)()
+	room := getRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if _, ok := getRole(room, userId); !ok {
+		return nil, ErrPermissionDenied
+	}
+	if room.Status != RoomStatus_Open {
+		return buildClosedSettlement(room)
+	}
+	return buildSettlement(room), nil
 }
 
 // 不在任何房间的用户才能进入指定房间
 func EntryRoom(roomId, userId int) (bool, error) {
+	defer lockRoom(roomId)()
 	// 先检查房间是否活跃
 	room := getActiveRoom(roomId)
 	if room == nil {
@@ -77,6 +220,7 @@ func EntryRoom(roomId, userId int) (bool, error) {
 	}
 
 	// 构建下层数据
+	before := playerState(roomId, userId)
 	err := user.EntryRoom(roomId, userId)
 	if err != nil {
 		return false, err
@@ -84,12 +228,14 @@ func EntryRoom(roomId, userId int) (bool, error) {
 
 	// 构建本层数据
 	room.Players[userId] = userId
-	setRoom2Redis(room, 0)
+	auditPlayer(roomId, userId, audit.ACTION_ENTRY_ROOM, before)
+	publish(room, event.EVENT_ENTRY_ROOM, userId, nil)
 	return true, nil
 }
 
 // 进入房间之后才能加入该房间的游戏
 func JoinGame(roomId, userId int) (bool, error) {
+	defer lockRoom(roomId)()
 	// 先检查房间是否活跃
 	roomInfo := getActiveRoom(roomId)
 	if roomInfo == nil {
@@ -100,27 +246,40 @@ func JoinGame(roomId, userId int) (bool, error) {
 		return false, errors.New("user not in this room")
 	}
 
+	// 观众不能参与游戏
+	if err := checkPermission(roomInfo, userId, PERM_PLAY); err != nil {
+		return false, err
+	}
+
+	before := playerState(roomId, userId)
 	if err := user.JoinGame(roomId, userId); err != nil {
 		return false, err
 	}
+	auditPlayer(roomId, userId, audit.ACTION_JOIN_GAME, before)
+	publish(roomInfo, event.EVENT_JOIN_GAME, userId, nil)
 	return true, nil
 }
 
 func QuitGame(roomId, userId int) (bool, error) {
+	defer lockRoom(roomId)()
 	roomInfo := getActiveRoom(roomId)
 	if roomInfo == nil {
 		return false, errors.New("room not existed")
 	}
 
+	before := playerState(roomId, userId)
 	if err := user.QuitGame(roomId, userId); err != nil {
 		return false, err
 	}
 
+	auditPlayer(roomId, userId, audit.ACTION_QUIT_GAME, before)
+	publish(roomInfo, event.EVENT_QUIT_GAME, userId, nil)
 	return true, nil
 }
 
 // 退出房间不会导致数据变化
 func LeaveRoom(roomId, userId int) (bool, error) {
+	defer lockRoom(roomId)()
 	// 房间不是活跃状态
 	room := getActiveRoom(roomId)
 	if room == nil {
@@ -128,50 +287,317 @@ func LeaveRoom(roomId, userId int) (bool, error) {
 	}
 
 	// 清理下层数据
+	before := playerState(roomId, userId)
 	if err := user.LeaveRoom(roomId, userId); err != nil {
 		return false, err
 	}
+	auditPlayer(roomId, userId, audit.ACTION_LEAVE_ROOM, before)
 
-	// 清理本层数据
-	// room.Players[userId] = userId
-	delete(room.Players, userId)
-	setRoom2Redis(room, 0)
+	// 清理本层数据，参与过游戏的玩家需要保留，以便结算
+	if !user.HasJoinedGame(roomId, userId) {
+		delete(room.Players, userId)
+	}
+	publish(room, event.EVENT_LEAVE_ROOM, userId, nil)
 	return true, nil
 }
 
-func GetRoomInfo(roomId int) (*RoomInfo, error) {
+// 只有房间成员可以查看房间详情
+func GetRoomInfo(roomId, userId int) (*RoomInfo, error) {
+	defer lockRoom(roomId)()
 	roomInfo := getActiveRoom(roomId)
 	if roomInfo == nil {
 		return nil, errors.New("room not existed")
 	}
-	return roomInfo, nil
+	if _, ok := getRole(roomInfo, userId); !ok {
+		return nil, ErrPermissionDenied
+	}
+	return roomInfo.snapshot(), nil
 }
 
 func ApplyBuyIn(roomId, userId, score, applyType int) (*records.ApplyScore, error) {
+	defer lockRoom(roomId)()
 	room := getActiveRoom(roomId)
 	if room == nil {
 		return nil, errors.New("room not exist")
 	}
+	if err := checkPermission(room, userId, PERM_PLAY); err != nil {
+		return nil, err
+	}
 
-	return user.ApplyBuyIn(roomId, userId, score, applyType)
+	if err := records.CheckApplyScore(score, applyType); err != nil {
+		return nil, err
+	}
+	if applyType == records.APPLY_TYPE_BUYIN {
+		if err := checkBuyInPolicy(room, userId, score, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	apply, err := user.ApplyBuyIn(roomId, userId, score, applyType)
+	if err != nil {
+		return nil, err
+	}
+	auditApply(roomId, userId, audit.ACTION_APPLY, nil, apply)
+	publish(room, event.EVENT_APPLY, userId, apply)
+	return tryAutoApprove(room, apply), nil
 }
 
-func ConfirmBuyIn(roomId, owner, applyId, status int) (*records.ApplyScore, error) {
+func ConfirmBuyIn(roomId, operator, applyId, status int) (*records.ApplyScore, error) {
+	defer lockRoom(roomId)()
 	room := getActiveRoom(roomId)
 	if room == nil {
 		return nil, errors.New("room not exist")
 	}
-	if room.Owner != owner {
-		return nil, errors.New("only room owner can confirm applies")
+	if err := checkPermission(room, operator, PERM_CONFIRM_APPLY); err != nil {
+		return nil, err
+	}
+	return confirmApply(room, operator, applyId, status)
+}
+
+// 审批单个申请，调用方需要持有房间锁并检查权限
+func confirmApply(room *RoomInfo, operator, applyId, status int) (*records.ApplyScore, error) {
+	// 只能审批本房间的申请
+	origin, err := getRoomApply(room.RoomId, applyId)
+	if err != nil {
+		return nil, err
+	}
+	// 代录的结算由玩家确认
+	if isProxyCashOut(origin) {
+		return nil, ErrNeedPlayerAck
 	}
-	return user.ConfirmBuyIn(applyId, status)
+	apply, err := user.ConfirmBuyIn(applyId, status)
+	if err != nil {
+		return nil, err
+	}
+	auditConfirm(room.RoomId, operator, origin, apply)
+	publish(room, event.EVENT_CONFIRM, apply.UserId, apply)
+	return apply, nil
 }
 
-func GetAllScoreApplies(roomId int) ([]records.ApplyScore, error) {
+// 批量审批，all为true时处理房间内所有待审批的申请
+// 每个申请单独处理，部分失败不影响其他申请，返回每个申请的结果
+func ConfirmBuyInBatch(roomId, operator int, applyIds []int, all bool, status int) ([]ConfirmResult, error) {
+	defer lockRoom(roomId)()
 	room := getActiveRoom(roomId)
 	if room == nil {
 		return nil, errors.New("room not exist")
 	}
+	if err := checkPermission(room, operator, PERM_CONFIRM_APPLY); err != nil {
+		return nil, err
+	}
+
+	if all {
+		applies, err := records.GetApplyScoreAll(roomId)
+		if err != nil {
+			return nil, err
+		}
+		// 待审批的申请过多时只处理前MAX_BATCH_CONFIRM个，客户端可以重复提交处理剩余的申请
+		applyIds = []int{}
+		for _, apply := range applies {
+			if len(applyIds) == MAX_BATCH_CONFIRM {
+				break
+			}
+			if apply.Status == records.APPLY_STATUS_APPLY && !isProxyCashOut(&apply) {
+				applyIds = append(applyIds, apply.Id)
+			}
+		}
+	} else if len(applyIds) > MAX_BATCH_CONFIRM {
+		return nil, errors.New(fmt.Sprintf("一次最多审批%d个申请", MAX_BATCH_CONFIRM))
+	}
+
+	results := []ConfirmResult{}
+	handled := map[int]bool{}
+	for _, applyId := range applyIds {
+		if handled[applyId] {
+			continue
+		}
+		handled[applyId] = true
+		apply, err := confirmApply(room, operator, applyId, status)
+		results = append(results, ConfirmResult{
+			ApplyId: applyId,
+			Apply:   apply,
+			Err:     err,
+		})
+	}
+	return results, nil
+}
+
+// 检查申请属于该房间，调用方需要持有房间锁
+func getRoomApply(roomId, applyId int) (*records.ApplyScore, error) {
+	apply, err := records.GetApply(applyId)
+	if err != nil {
+		return nil, err
+	}
+	if apply.RoomId != roomId {
+		return nil, errors.New("apply not in this room")
+	}
+	return apply, nil
+}
+
+// 玩家在审批前撤回自己的申请
+func CancelApply(roomId, userId, applyId int) (*records.ApplyScore, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	origin, err := getRoomApply(roomId, applyId)
+	if err != nil {
+		return nil, err
+	}
+	if isProxyCashOut(origin) {
+		return nil, ErrProxyCashOutLocked
+	}
+
+	apply, err := user.CancelApply(roomId, userId, applyId)
+	if err != nil {
+		return nil, err
+	}
+	auditApply(roomId, userId, audit.ACTION_CANCEL_APPLY, origin, apply)
+	publish(room, event.EVENT_CANCEL, userId, apply)
+	return apply, nil
+}
+
+// 玩家在审批前修改自己申请的积分
+func AmendApply(roomId, userId, applyId, score int) (*records.ApplyScore, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	origin, err := getRoomApply(roomId, applyId)
+	if err != nil {
+		return nil, err
+	}
+	if isProxyCashOut(origin) {
+		return nil, ErrProxyCashOutLocked
+	}
+	// 修改后的积分按新申请检查，不计入被修改的申请
+	if origin.ApplyType == records.APPLY_TYPE_BUYIN {
+		if err := checkBuyInPolicy(room, origin.UserId, score, applyId); err != nil {
+			return nil, err
+		}
+	}
+
+	apply, err := user.AmendApply(roomId, userId, applyId, score)
+	if err != nil {
+		return nil, err
+	}
+	auditApply(roomId, userId, audit.ACTION_AMEND_APPLY, origin, apply)
+	publish(room, event.EVENT_AMEND, userId, apply)
+	return apply, nil
+}
+
+// 房主冲正误操作确认的记录，原记录保留，账目只增不改
+func ReverseRecord(roomId, operator, applyId int) (*records.ApplyScore, error) {
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_REVERSE_RECORD); err != nil {
+		return nil, err
+	}
+	if _, err := getRoomApply(roomId, applyId); err != nil {
+		return nil, err
+	}
+
+	reversal, err := user.ReverseRecord(applyId)
+	if err != nil {
+		return nil, err
+	}
+	auditApply(roomId, operator, audit.ACTION_REVERSE, nil, reversal)
+	publish(room, event.EVENT_REVERSE, reversal.UserId, reversal)
+	return reversal, nil
+}
+
+// 房主、副房主或庄家代玩家录入买入或结算，直接生效，用于玩家无法自己提交的情况
+func ProxyRecord(roomId, operator, userId, score, applyType int) (*records.ApplyScore, error) {
+	if err := records.CheckApplyScore(score, applyType); err != nil {
+		return nil, err
+	}
+
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_PROXY_RECORD); err != nil {
+		return nil, err
+	}
+	if _, ok := room.Players[userId]; !ok {
+		return nil, errors.New("user not in this room")
+	}
+	// 观众不能买入，代录的买入同样受房间买入限制
+	if applyType == records.APPLY_TYPE_BUYIN {
+		if err := checkPermission(room, userId, PERM_PLAY); err != nil {
+			return nil, err
+		}
+		if err := checkBuyInPolicy(room, userId, score, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	// 双向确认时代录的结算需要玩家确认
+	pending := room.TwoSidedCashOut && applyType == records.APPLY_TYPE_CASHOUT
+	apply, err := user.AddProxyRecord(roomId, userId, score, applyType, operator, pending)
+	if err != nil {
+		return nil, err
+	}
+	auditApply(roomId, operator, audit.ACTION_PROXY, nil, apply)
+	publish(room, event.EVENT_PROXY, userId, apply)
+	return apply, nil
+}
+
+// 房主或副房主手动调整玩家的积分，必须填写原因，立即生效
+func AdjustScore(roomId, operator, userId, score int, reason string) (*records.ApplyScore, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, errors.New("请填写调整原因")
+	}
+	if utf8.RuneCountInString(reason) > MAX_ADJUST_REASON_LENGTH {
+		return nil, errors.New(fmt.Sprintf("调整原因不能超过%d个字", MAX_ADJUST_REASON_LENGTH))
+	}
+	if score == 0 {
+		return nil, errors.New("调整积分不能为0")
+	}
+
+	defer lockRoom(roomId)()
+	room := getActiveRoom(roomId)
+	if room == nil {
+		return nil, errors.New("room not exist")
+	}
+	if err := checkPermission(room, operator, PERM_ADJUST_SCORE); err != nil {
+		return nil, err
+	}
+	if _, ok := room.Players[userId]; !ok {
+		return nil, errors.New("user not in this room")
+	}
+
+	apply, err := user.AddAdjustment(roomId, userId, score, reason)
+	if err != nil {
+		return nil, err
+	}
+	auditApply(roomId, operator, audit.ACTION_ADJUST, nil, apply)
+	publish(room, event.EVENT_ADJUST, userId, apply)
+	return apply, nil
+}
+
+// 房间内的所有记录，包括冲正记录，房间关闭后仍然可以查看
+func GetRoomHistory(roomId, userId int) ([]records.ApplyScore, error) {
+	if !IsMember(roomId, userId) {
+		return nil, ErrPermissionDenied
+	}
+	return user.GetRoomHistory(roomId)
+}
+
+func GetAllScoreApplies(roomId, userId int) ([]records.ApplyScore, error) {
+	if CheckRoom(roomId) == nil {
+		return nil, errors.New("room not exist")
+	}
+	if !IsMember(roomId, userId) {
+		return nil, ErrPermissionDenied
+	}
 
 	return user.GetAllScoreApplies(roomId)
 }
@@ -188,19 +614,37 @@ func ClearUnusedRooms() error {
 	roomMap := map[int]int{}
 	userMap := map[int]int{}
 	for _, room := range openingRooms {
-		roomMap[room.RoomID] = room.Owner
-		delRoomFromRedis(room.RoomID)
-		view.CloseRoom(room.RoomID, room.Owner)
-
-		currRoom := getRoom(room.RoomID)
-		if currRoom == nil || currRoom.Players == nil {
-			continue
-		}
-		for _, playerId := range currRoom.Players {
-			userMap[playerId] = playerId
-		}
+		closeUnusedRoom(room.ID, room.Owner, room.Code, userMap)
+		roomMap[room.ID] = room.Owner
 	}
 	// 清理用户存储的信息
 	user.ClearUnusedRooms(userMap, roomMap)
 	return nil
 }
+
+// 关闭单个房间，并收集房间内的用户
+func closeUnusedRoom(roomId, owner, code int, userMap map[int]int) {
+	defer lockRoom(roomId)()
+	currRoom := getRoom(roomId)
+	before := &audit.RoomState{Status: RoomStatus_Open, Code: code}
+	if currRoom != nil {
+		before = roomState(currRoom)
+	}
+
+	delRoomFromRedis(roomId)
+	view.CloseRoom(roomId, owner)
+	releaseRoomCode(code)
+	after := &audit.RoomState{Status: RoomStatus_Close, Code: code}
+	audit.Record(roomId, audit.ACTOR_SYSTEM, audit.ACTION_CLEAR_ROOM, audit.TARGET_ROOM, roomId, before, after)
+
+	if currRoom == nil || currRoom.Players == nil {
+		// 没有载入的房间不会推送关闭事件，直接释放事件缓冲区
+		event.ReleaseRoom(roomId)
+		return
+	}
+	currRoom.Status = RoomStatus_Close
+	for _, playerId := range currRoom.Players {
+		userMap[playerId] = playerId
+	}
+	publish(currRoom, event.EVENT_CLOSE_ROOM, 0, nil)
+}
@@ -0,0 +1,252 @@
+package room
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/jianshao/poker_counter/src/model/records"
+	"github.com/jianshao/poker_counter/src/model/user"
+	"github.com/jianshao/poker_counter/src/utils"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+const (
+	TEST_PLAYERS       = 20
+	TEST_APPLIES       = 4 // 每个玩家的买入申请次数
+	TEST_CONFIRMERS    = 3 // 同时审批同一个申请的协程数量
+	TEST_BATCH_WORKERS = 2
+)
+
+// 使用内存存储和内存redis，创建一个房主为owner的空房间
+func setupRoom(t *testing.T, owner int) (*RoomInfo, *memStore) {
+	store := newMemStore()
+	prev := view.SetStore(store)
+	utils.SetRedisPool(newMemRedisPool())
+	t.Cleanup(func() {
+		view.SetStore(prev)
+		utils.SetRedisPool(nil)
+	})
+	if _, err := user.ClearCache(); err != nil {
+		t.Fatalf("clear user cache failed: %s", err)
+	}
+
+	room := setRoom2Map(&RoomInfo{
+		RoomId:  nextId(),
+		Code:    1001,
+		Owner:   owner,
+		Status:  RoomStatus_Open,
+		Players: map[int]int{},
+		BuyIn:   defaultBuyInPolicy(),
+	})
+	return room, store
+}
+
+// 记录每个申请被审批通过的次数
+type confirmCounter struct {
+	lock   sync.Mutex
+	counts map[int]int
+}
+
+func (c *confirmCounter) add(applyId int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.counts[applyId] += 1
+}
+
+// 并发审批同一个申请时，只允许出现已被处理的错误
+func checkConfirmErr(t *testing.T, err error) {
+	if err != nil && !errors.Is(err, records.ErrApplyProcessed) {
+		t.Errorf("unexpected confirm error: %s", err)
+	}
+}
+
+// 玩家并发进入房间、加入游戏并申请买入，同时房主逐个审批和批量审批，
+// 每个申请只能被通过一次，玩家的买入积分等于其申请之和
+func TestConcurrentEntryJoinApplyConfirm(t *testing.T) {
+	owner := nextId()
+	room, store := setupRoom(t, owner)
+	roomId := room.RoomId
+
+	counter := &confirmCounter{counts: map[int]int{}}
+	applied := make(chan int, TEST_PLAYERS*TEST_APPLIES)
+	expected := map[int]int{}
+	playerIds := []int{}
+	for i := 0; i < TEST_PLAYERS; i++ {
+		playerId := nextId()
+		playerIds = append(playerIds, playerId)
+		for j := 1; j <= TEST_APPLIES; j++ {
+			expected[playerId] += 100 * j
+		}
+	}
+
+	var players sync.WaitGroup
+	for _, playerId := range playerIds {
+		players.Add(1)
+		go func(playerId int) {
+			defer players.Done()
+			if _, err := EntryRoom(roomId, playerId); err != nil {
+				t.Errorf("entry room failed: %s", err)
+				return
+			}
+			if _, err := JoinGame(roomId, playerId); err != nil {
+				t.Errorf("join game failed: %s", err)
+				return
+			}
+			for j := 1; j <= TEST_APPLIES; j++ {
+				apply, err := ApplyBuyIn(roomId, playerId, 100*j, records.APPLY_TYPE_BUYIN)
+				if err != nil {
+					t.Errorf("apply buy-in failed: %s", err)
+					return
+				}
+				applied <- apply.Id
+			}
+		}(playerId)
+	}
+
+	// 每个申请由多个协程同时审批
+	var confirmers sync.WaitGroup
+	for i := 0; i < TEST_CONFIRMERS; i++ {
+		confirmers.Add(1)
+		go func() {
+			defer confirmers.Done()
+			for applyId := range applied {
+				var wg sync.WaitGroup
+				for k := 0; k < TEST_CONFIRMERS; k++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						_, err := ConfirmBuyIn(roomId, owner, applyId, records.APPLY_STATUS_ACCEPT)
+						checkConfirmErr(t, err)
+						if err == nil {
+							counter.add(applyId)
+						}
+					}()
+				}
+				wg.Wait()
+			}
+		}()
+	}
+
+	// 批量审批与逐个审批同时进行
+	done := make(chan struct{})
+	var batchers sync.WaitGroup
+	for i := 0; i < TEST_BATCH_WORKERS; i++ {
+		batchers.Add(1)
+		go func() {
+			defer batchers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				results, err := ConfirmBuyInBatch(roomId, owner, nil, true, records.APPLY_STATUS_ACCEPT)
+				if err != nil {
+					t.Errorf("batch confirm failed: %s", err)
+					return
+				}
+				for _, result := range results {
+					checkConfirmErr(t, result.Err)
+					if result.Err == nil {
+						counter.add(result.ApplyId)
+					}
+				}
+			}
+		}()
+	}
+
+	players.Wait()
+	close(applied)
+	confirmers.Wait()
+	close(done)
+	batchers.Wait()
+
+	if len(counter.counts) != TEST_PLAYERS*TEST_APPLIES {
+		t.Fatalf("confirmed %d applies, want %d", len(counter.counts), TEST_PLAYERS*TEST_APPLIES)
+	}
+	for applyId, count := range counter.counts {
+		if count != 1 {
+			t.Errorf("apply %d confirmed %d times", applyId, count)
+		}
+	}
+
+	total := 0
+	for _, playerId := range playerIds {
+		player := user.GetUser(playerId)
+		if player == nil {
+			t.Fatalf("user %d not exist", playerId)
+		}
+		info := player.Rooms[roomId]
+		if info.CurrScore != expected[playerId] {
+			t.Errorf("user %d buy-in %d, want %d", playerId, info.CurrScore, expected[playerId])
+		}
+		if saved := store.players[[2]int{roomId, playerId}]; saved.CurrScore != expected[playerId] {
+			t.Errorf("user %d saved buy-in %d, want %d", playerId, saved.CurrScore, expected[playerId])
+		}
+		total += info.CurrScore
+	}
+
+	balance, err := records.GetRoomBalance(roomId)
+	if err != nil {
+		t.Fatalf("get room balance failed: %s", err)
+	}
+	if balance.BuyIn != total {
+		t.Errorf("room buy-in %d, want %d", balance.BuyIn, total)
+	}
+	if got := len(room.snapshot().Players); got != TEST_PLAYERS {
+		t.Errorf("room has %d players, want %d", got, TEST_PLAYERS)
+	}
+}
+
+// 同一个申请同时被通过和拒绝，只有一个能成功，积分以成功的结果为准
+func TestConcurrentAcceptAndReject(t *testing.T) {
+	owner := nextId()
+	room, _ := setupRoom(t, owner)
+	roomId := room.RoomId
+
+	playerId := nextId()
+	if _, err := EntryRoom(roomId, playerId); err != nil {
+		t.Fatalf("entry room failed: %s", err)
+	}
+	if _, err := JoinGame(roomId, playerId); err != nil {
+		t.Fatalf("join game failed: %s", err)
+	}
+	apply, err := ApplyBuyIn(roomId, playerId, 500, records.APPLY_TYPE_BUYIN)
+	if err != nil {
+		t.Fatalf("apply buy-in failed: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	succeeded := []int{}
+	for i := 0; i < 16; i++ {
+		status := records.APPLY_STATUS_ACCEPT
+		if i%2 == 1 {
+			status = records.APPLY_STATUS_REJECT
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ConfirmBuyIn(roomId, owner, apply.Id, status)
+			checkConfirmErr(t, err)
+			if err == nil {
+				lock.Lock()
+				succeeded = append(succeeded, status)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(succeeded) != 1 {
+		t.Fatalf("apply confirmed %d times, want 1", len(succeeded))
+	}
+	want := 0
+	if succeeded[0] == records.APPLY_STATUS_ACCEPT {
+		want = 500
+	}
+	if got := user.GetUser(playerId).Rooms[roomId].CurrScore; got != want {
+		t.Errorf("buy-in %d, want %d", got, want)
+	}
+}
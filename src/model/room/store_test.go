@@ -0,0 +1,257 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jianshao/poker_counter/prisma/db"
+	"github.com/jianshao/poker_counter/src/view"
+)
+
+// 测试用的内存存储，只实现房间常规操作用到的读写，调用其他方法会panic
+// 审批与数据库的行为一致：只修改仍处于申请状态的记录，同意时一并累加玩家积分
+type memStore struct {
+	view.Store
+	lock    sync.Mutex
+	records map[int]*db.ScoreRecordsModel
+	players map[[2]int]*db.RoomPlayerModel
+	audits  int
+}
+
+// 记录ID在所有测试之间递增，避免与records包中缓存的申请冲突
+var (
+	gNextId   int
+	gNextLock sync.Mutex
+)
+
+func nextId() int {
+	gNextLock.Lock()
+	defer gNextLock.Unlock()
+	gNextId += 1
+	return gNextId
+}
+
+// view只导出了从数据库枚举到整数的映射
+func reverseLookup[K comparable](values map[K]int, value int) K {
+	for key, v := range values {
+		if v == value {
+			return key
+		}
+	}
+	var zero K
+	return zero
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		records: map[int]*db.ScoreRecordsModel{},
+		players: map[[2]int]*db.RoomPlayerModel{},
+	}
+}
+
+func (s *memStore) GetUserById(userId int) (*db.UserModel, error) {
+	user := &db.UserModel{}
+	user.ID = userId
+	user.Name = fmt.Sprintf("user%d", userId)
+	return user, nil
+}
+
+func (s *memStore) GetUserRoomPlayers(userId int) ([]db.RoomPlayerModel, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	players := []db.RoomPlayerModel{}
+	for key, player := range s.players {
+		if key[1] == userId {
+			players = append(players, *player)
+		}
+	}
+	return players, nil
+}
+
+// 测试中的房间都未关闭
+func (s *memStore) GetOpenRoomsByIds(roomIds []int) ([]db.RoomModel, error) {
+	rooms := []db.RoomModel{}
+	for _, roomId := range roomIds {
+		room := db.RoomModel{}
+		room.ID = roomId
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+func (s *memStore) SaveRoomPlayer(data *view.RoomPlayerData) (*db.RoomPlayerModel, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	player := &db.RoomPlayerModel{}
+	player.RoomID = data.RoomId
+	player.UID = data.UserId
+	player.Status = reverseLookup(view.PlayerStatus2int, data.Status)
+	player.Role = reverseLookup(view.Role2int, data.Role)
+	player.InRoom = data.InRoom
+	player.CurrScore = data.CurrScore
+	player.FinalScore = data.FinalScore
+	player.JoinTime = data.JoinTime
+	player.ExitTime = data.ExitTime
+	s.players[[2]int{data.RoomId, data.UserId}] = player
+	playerCopy := *player
+	return &playerCopy, nil
+}
+
+func (s *memStore) InsertScoreApply(roomId, userId, score, applyType int) (*db.ScoreRecordsModel, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	record := &db.ScoreRecordsModel{}
+	record.ID = nextId()
+	record.RoomID = roomId
+	record.UID = userId
+	record.Score = score
+	record.Status = "APPLY"
+	record.Type = reverseLookup(view.Type2int, applyType)
+	record.CreatedTime = time.Now()
+	record.UpdatedTime = record.CreatedTime
+	s.records[record.ID] = record
+	recordCopy := *record
+	return &recordCopy, nil
+}
+
+func (s *memStore) ConfirmScoreApply(applyId, status int, auto bool) (*view.ConfirmedRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	record, ok := s.records[applyId]
+	if !ok || record.Status != "APPLY" {
+		return nil, db.ErrNotFound
+	}
+	record.Status = reverseLookup(view.Status2int, status)
+	record.Auto = auto
+	record.UpdatedTime = time.Now()
+
+	confirmed := &view.ConfirmedRecord{ScoreRecordsModel: *record}
+	player, ok := s.players[[2]int{record.RoomID, record.UID}]
+	if record.Status == "ACCEPT" && ok {
+		if record.Type == "BUYIN" {
+			player.CurrScore += record.Score
+		} else {
+			player.FinalScore += record.Score
+		}
+		currScore, finalScore := player.CurrScore, player.FinalScore
+		confirmed.CurrScore = &currScore
+		confirmed.FinalScore = &finalScore
+	}
+	return confirmed, nil
+}
+
+func (s *memStore) GetScoreRecordById(id int) (*db.ScoreRecordsModel, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	record, ok := s.records[id]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	recordCopy := *record
+	return &recordCopy, nil
+}
+
+func (s *memStore) findRecords(match func(record *db.ScoreRecordsModel) bool) []db.ScoreRecordsModel {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	records := []db.ScoreRecordsModel{}
+	for _, record := range s.records {
+		if match(record) {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+func (s *memStore) GetScoreRecords(roomId, status int) ([]db.ScoreRecordsModel, error) {
+	return s.findRecords(func(record *db.ScoreRecordsModel) bool {
+		return record.RoomID == roomId && view.Status2int[record.Status] == status
+	}), nil
+}
+
+func (s *memStore) GetScoreRecordsByType(roomId, recordType int) ([]db.ScoreRecordsModel, error) {
+	return s.findRecords(func(record *db.ScoreRecordsModel) bool {
+		return record.RoomID == roomId && view.Type2int[record.Type] == recordType
+	}), nil
+}
+
+func (s *memStore) GetUserScoreRecords(roomId, userId int) ([]db.ScoreRecordsModel, error) {
+	return s.findRecords(func(record *db.ScoreRecordsModel) bool {
+		return record.RoomID == roomId && record.UID == userId
+	}), nil
+}
+
+func (s *memStore) InsertAuditEvent(roomId, actor int, action, targetType string, targetId int, before, after []byte) (*db.AuditEventModel, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.audits += 1
+	return &db.AuditEventModel{}, nil
+}
+
+// 测试用的内存redis，只支持用到的命令
+type memRedis struct {
+	lock sync.Mutex
+	data map[string]string
+}
+
+func newMemRedisPool() *redis.Pool {
+	store := &memRedis{data: map[string]string{}}
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return &memRedisConn{store: store}, nil
+		},
+	}
+}
+
+type memRedisConn struct {
+	store *memRedis
+}
+
+func (c *memRedisConn) Close() error { return nil }
+func (c *memRedisConn) Err() error   { return nil }
+
+func (c *memRedisConn) Send(string, ...interface{}) error { return errors.New("not supported") }
+func (c *memRedisConn) Flush() error                      { return errors.New("not supported") }
+func (c *memRedisConn) Receive() (interface{}, error)     { return nil, errors.New("not supported") }
+
+func (c *memRedisConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.store.lock.Lock()
+	defer c.store.lock.Unlock()
+	data := c.store.data
+	switch cmd {
+	case "":
+		return nil, nil
+	case "GET":
+		value, ok := data[fmt.Sprint(args[0])]
+		if !ok {
+			return nil, nil
+		}
+		return []byte(value), nil
+	case "SET":
+		data[fmt.Sprint(args[0])] = fmt.Sprint(args[1])
+		return "OK", nil
+	case "DEL":
+		deleted := int64(0)
+		for _, key := range args {
+			if _, ok := data[fmt.Sprint(key)]; ok {
+				delete(data, fmt.Sprint(key))
+				deleted += 1
+			}
+		}
+		return deleted, nil
+	case "SCAN":
+		// 一次返回所有匹配的key
+		keys := []interface{}{}
+		for key := range data {
+			if ok, _ := path.Match(fmt.Sprint(args[2]), key); ok {
+				keys = append(keys, []byte(key))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}, nil
+	}
+	return nil, errors.New("command not supported: " + cmd)
+}
@@ -0,0 +1,85 @@
+package room
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// 等待房间变化的最长时间，避免连接被代理断开
+	MAX_WAIT_TIMEOUT = 60 * time.Second
+)
+
+// 房间的每次变化都会增加版本号，等待变化的请求在版本号增加时被唤醒
+var (
+	gVersionWaiters = map[int]chan struct{}{}
+	gVersionLock    sync.Mutex
+)
+
+func bumpVersion(room *RoomInfo) {
+	gVersionLock.Lock()
+	defer gVersionLock.Unlock()
+	room.Version += 1
+	if waiter, ok := gVersionWaiters[room.RoomId]; ok {
+		close(waiter)
+		delete(gVersionWaiters, room.RoomId)
+	}
+}
+
+// 版本号没有变化时返回用于等待的channel，版本号只在同时持有房间锁和该锁时修改
+func getVersionWaiter(roomId int, sinceVersion int64) (<-chan struct{}, bool) {
+	room := getRoom(roomId)
+	if room == nil {
+		return nil, true
+	}
+	gVersionLock.Lock()
+	defer gVersionLock.Unlock()
+	if room.Version != sinceVersion {
+		return nil, true
+	}
+	waiter, ok := gVersionWaiters[room.RoomId]
+	if !ok {
+		waiter = make(chan struct{})
+		gVersionWaiters[room.RoomId] = waiter
+	}
+	return waiter, false
+}
+
+// 等待房间版本号与sinceVersion不同，超时或请求取消时返回当前的房间信息
+func WaitRoomChange(ctx context.Context, roomId, userId int, sinceVersion int64, timeout time.Duration) (*RoomInfo, error) {
+	room, err := GetRoomInfo(roomId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if room.Version != sinceVersion || timeout <= 0 {
+		return room, nil
+	}
+	waiter, changed := getVersionWaiter(roomId, sinceVersion)
+	if changed {
+		return getRoomSnapshot(roomId, room), nil
+	}
+	if timeout > MAX_WAIT_TIMEOUT {
+		timeout = MAX_WAIT_TIMEOUT
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-waiter:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	// 等待期间房间可能被关闭，仍然返回最新状态
+	return getRoomSnapshot(roomId, room), nil
+}
+
+func getRoomSnapshot(roomId int, defaultRoom *RoomInfo) *RoomInfo {
+	defer lockRoom(roomId)()
+	if room := getRoom(roomId); room != nil {
+		return room.snapshot()
+	}
+	return defaultRoom
+}
@@ -40,6 +40,10 @@ func Init() error {
 		desc := "每天凌晨1点执行,清理超过3天未使用的且未关闭的房间"
 		AddSchedule("清理房间", desc, room.ClearUnusedRooms, processTime, 24*3600, schedule.SCHEDULE_TYPE_INTERVAL)
 
+		// 增加申请过期任务：每分钟执行，超过房间超时时间仍未审批的申请置为过期
+		desc = "每分钟执行,超时未审批的申请置为过期"
+		AddSchedule("申请过期", desc, room.ExpireStaleApplies, time.Now(), room.EXPIRE_CHECK_INTERVAL, schedule.SCHEDULE_TYPE_INTERVAL)
+
 		// 运行任务
 		schedule.Run()
 	}()
@@ -0,0 +1,99 @@
+package settlement
+
+import "sort"
+
+// 房间作为结算参与方时使用的ID
+const HOUSE_ID = 0
+
+// 玩家在房间内的输赢结果
+type PlayerResult struct {
+	UserId  int
+	Name    string
+	BuyIn   int
+	CashOut int
+	Net     int
+}
+
+// 一笔转账：From 付给 To Amount 积分
+type Transfer struct {
+	From     int
+	FromName string
+	To       int
+	ToName   string
+	Amount   int
+}
+
+type Settlement struct {
+	RoomId    int
+	Players   []PlayerResult
+	Transfers []Transfer
+}
+
+type balance struct {
+	userId int
+	name   string
+	amount int
+}
+
+// 按金额从大到小排序，金额相同时按用户ID排序，保证结果稳定
+func sortBalances(list []*balance) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].amount != list[j].amount {
+			return list[i].amount > list[j].amount
+		}
+		return list[i].userId < list[j].userId
+	})
+}
+
+// 计算转账方案：每次让欠得最多的玩家付给赢得最多的玩家，转账笔数不超过玩家数-1
+func Settle(players []PlayerResult) []Transfer {
+	debtors := []*balance{}
+	creditors := []*balance{}
+	for _, player := range players {
+		if player.Net < 0 {
+			debtors = append(debtors, &balance{userId: player.UserId, name: player.Name, amount: -player.Net})
+		} else if player.Net > 0 {
+			creditors = append(creditors, &balance{userId: player.UserId, name: player.Name, amount: player.Net})
+		}
+	}
+
+	transfers := []Transfer{}
+	for len(debtors) > 0 && len(creditors) > 0 {
+		sortBalances(debtors)
+		sortBalances(creditors)
+
+		debtor, creditor := debtors[0], creditors[0]
+		amount := min(debtor.amount, creditor.amount)
+		transfers = append(transfers, Transfer{
+			From:     debtor.userId,
+			FromName: debtor.name,
+			To:       creditor.userId,
+			ToName:   creditor.name,
+			Amount:   amount,
+		})
+
+		debtor.amount -= amount
+		creditor.amount -= amount
+		if debtor.amount == 0 {
+			debtors = debtors[1:]
+		}
+		if creditor.amount == 0 {
+			creditors = creditors[1:]
+		}
+	}
+	return transfers
+}
+
+func NewSettlement(roomId int, players []PlayerResult) *Settlement {
+	for i := range players {
+		players[i].Net = players[i].CashOut - players[i].BuyIn
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].Net > players[j].Net
+	})
+	return &Settlement{
+		RoomId:    roomId,
+		Players:   players,
+		Transfers: Settle(players),
+	}
+}
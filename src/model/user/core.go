@@ -3,7 +3,10 @@ package user
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 
+	"github.com/jianshao/poker_counter/prisma/db"
+	"github.com/jianshao/poker_counter/src/model/records"
 	"github.com/jianshao/poker_counter/src/utils"
 	"github.com/jianshao/poker_counter/src/view"
 )
@@ -20,6 +23,7 @@ type PlayerInfo struct {
 // 用户在房间内的动态数据
 type UserRoomInfo struct {
 	Status     int
+	Role       int
 	CurrScore  int
 	FinalScore int
 	JoinTime   string
@@ -28,25 +32,67 @@ type UserRoomInfo struct {
 }
 
 var (
-	gUserMap = map[int]*PlayerInfo{}
+	gUserMap  = map[int]*PlayerInfo{}
+	gUserLock sync.RWMutex
+	// 同一个用户的修改需要串行执行，持有房间锁时可以再获取用户锁，反之不行
+	gUserLocks utils.KeyedMutex
 )
 
 const (
 	USER_STATUS_WATCHING = 0
 	USER_STATUS_PLAYING  = 1
 	USER_STATUS_QUIT     = 2
+
+	// 用户在房间内的角色，房主由房间决定
+	USER_ROLE_PLAYER    = 0
+	USER_ROLE_SPECTATOR = 1
+	USER_ROLE_BANKER    = 2
+	USER_ROLE_COHOST    = 3
+	USER_ROLE_OWNER     = 4
 )
 
+func lockUser(userId int) func() {
+	return gUserLocks.Lock(userId)
+}
+
+// 返回用户数据的副本，可以在用户锁之外安全地读取
 func GetUser(userId int) *PlayerInfo {
-	if user, ok := gUserMap[userId]; ok {
+	defer lockUser(userId)()
+	return copyUser(getUser(userId))
+}
+
+// 返回缓存中的用户数据，调用方需要持有用户锁
+func getUser(userId int) *PlayerInfo {
+	gUserLock.RLock()
+	user, ok := gUserMap[userId]
+	gUserLock.RUnlock()
+	if ok {
 		return user
 	}
 	// TODO:
 	return loadUser(userId)
 }
 
+func copyUser(user *PlayerInfo) *PlayerInfo {
+	if user == nil {
+		return nil
+	}
+	player := *user
+	player.Rooms = make(map[int]*UserRoomInfo, len(user.Rooms))
+	for roomId, room := range user.Rooms {
+		info := *room
+		info.ApplyList = make(map[int]int, len(room.ApplyList))
+		for applyId := range room.ApplyList {
+			info.ApplyList[applyId] = applyId
+		}
+		player.Rooms[roomId] = &info
+	}
+	return &player
+}
+
 func IsUserPlaying(roomId, userId int) bool {
-	user := GetUser(userId)
+	defer lockUser(userId)()
+	user := getUser(userId)
 	if user == nil {
 		return false
 	}
@@ -57,23 +103,165 @@ func IsUserPlaying(roomId, userId int) bool {
 	return false
 }
 
-// 从database中拉取用户数据
+// 获取用户在房间内的角色，用户不在房间内时返回false
+func GetRoomRole(roomId, userId int) (int, bool) {
+	defer lockUser(userId)()
+	user := getUser(userId)
+	if user == nil {
+		return 0, false
+	}
+
+	room, ok := user.Rooms[roomId]
+	if !ok {
+		return 0, false
+	}
+	return room.Role, true
+}
+
+// 参与过游戏的用户，其积分需要计入房间结算
+func HasJoinedGame(roomId, userId int) bool {
+	defer lockUser(userId)()
+	user := getUser(userId)
+	if user == nil {
+		return false
+	}
+
+	if room, ok := user.Rooms[roomId]; ok && room.JoinTime != "" {
+		return true
+	}
+	return false
+}
+
+// 从database中拉取用户数据，房间内的状态以RoomPlayer表为准
 func loadUserFromData(userId int) (*PlayerInfo, error) {
 	user, err := view.GetUserById(userId)
 	if err != nil {
 		return nil, err
 	}
-	return &PlayerInfo{
+	player := &PlayerInfo{
 		Id:    user.ID,
 		Name:  user.Name,
 		Rooms: map[int]*UserRoomInfo{},
+	}
+
+	roomPlayers, err := view.GetUserRoomPlayers(userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(roomPlayers) == 0 {
+		return player, nil
+	}
+
+	// 只恢复未关闭房间的数据
+	roomIds := []int{}
+	for _, roomPlayer := range roomPlayers {
+		roomIds = append(roomIds, roomPlayer.RoomID)
+	}
+	openRooms, err := view.GetOpenRoomsByIds(roomIds)
+	if err != nil {
+		return nil, err
+	}
+	openRoomMap := map[int]bool{}
+	for _, room := range openRooms {
+		openRoomMap[room.ID] = true
+	}
+
+	for _, roomPlayer := range roomPlayers {
+		if !openRoomMap[roomPlayer.RoomID] {
+			continue
+		}
+		info, err := loadUserRoomFromData(&roomPlayer)
+		if err != nil {
+			return nil, err
+		}
+		player.Rooms[roomPlayer.RoomID] = info
+		// 记录按更新时间倒序，第一个在房间内的即为当前房间
+		if roomPlayer.InRoom && player.CurrRoomId == 0 {
+			player.CurrRoomId = roomPlayer.RoomID
+		}
+	}
+	return player, nil
+}
+
+func loadUserRoomFromData(roomPlayer *db.RoomPlayerModel) (*UserRoomInfo, error) {
+	scoreRecords, err := view.GetUserScoreRecords(roomPlayer.RoomID, roomPlayer.UID)
+	if err != nil {
+		return nil, err
+	}
+	applyList := map[int]int{}
+	for _, record := range scoreRecords {
+		// 撤回和过期的申请不计入申请列表
+		status := view.Status2int[record.Status]
+		if status == records.APPLY_STATUS_CANCELLED || status == records.APPLY_STATUS_EXPIRED {
+			continue
+		}
+		applyList[record.ID] = record.ID
+	}
+	return &UserRoomInfo{
+		Status:     view.PlayerStatus2int[roomPlayer.Status],
+		Role:       view.Role2int[roomPlayer.Role],
+		CurrScore:  roomPlayer.CurrScore,
+		FinalScore: roomPlayer.FinalScore,
+		JoinTime:   roomPlayer.JoinTime,
+		ExitTime:   roomPlayer.ExitTime,
+		ApplyList:  applyList,
 	}, nil
 }
 
+// 先写入database，再更新redis缓存；写入失败时丢弃缓存，下次从database重新载入
+func saveUserRoom(user *PlayerInfo, roomId int) error {
+	room, ok := user.Rooms[roomId]
+	if !ok {
+		return nil
+	}
+	_, err := view.SaveRoomPlayer(&view.RoomPlayerData{
+		RoomId:     roomId,
+		UserId:     user.Id,
+		Status:     room.Status,
+		Role:       room.Role,
+		InRoom:     user.CurrRoomId == roomId,
+		CurrScore:  room.CurrScore,
+		FinalScore: room.FinalScore,
+		JoinTime:   room.JoinTime,
+		ExitTime:   room.ExitTime,
+	})
+	if err != nil {
+		invalidateUser(user.Id)
+		return err
+	}
+	return setUser2Redis(user, 0)
+}
+
+func invalidateUser(userId int) {
+	gUserLock.Lock()
+	delete(gUserMap, userId)
+	gUserLock.Unlock()
+	utils.Del(buildUserKey(userId))
+}
+
+// 丢弃缓存，从database重建用户数据，调用方需要持有用户锁
+func reloadUser(userId int) *PlayerInfo {
+	invalidateUser(userId)
+	return loadUser(userId)
+}
+
+func ReloadUser(userId int) *PlayerInfo {
+	defer lockUser(userId)()
+	return copyUser(reloadUser(userId))
+}
+
 func buildUserKey(userId int) string {
 	return fmt.Sprintf("User:%d", userId)
 }
 
+// 删除所有用户的缓存，之后访问时从database重建，key的格式见buildUserKey
+func ClearCache() (int, error) {
+	gUserLock.Lock()
+	gUserMap = map[int]*PlayerInfo{}
+	gUserLock.Unlock()
+	return utils.DelKeys("User:*")
+}
+
 func loadUserFromRedis(userId int) (*PlayerInfo, error) {
 	key := buildUserKey(userId)
 	userStr, err := utils.GetString(key)
@@ -98,24 +286,34 @@ func setUser2Redis(user *PlayerInfo, timeout int) error {
 	return utils.SetString(key, string(userStr), timeout)
 }
 
+func setUser2Map(user *PlayerInfo) {
+	gUserLock.Lock()
+	defer gUserLock.Unlock()
+	gUserMap[user.Id] = user
+}
+
 // 载入完成需要保证，本地缓存、redis、database中都有相同的数据
+// 调用方需要持有用户锁，避免同一个用户被重复载入
 func loadUser(userId int) *PlayerInfo {
 	// 如果本地缓存有，则代表redis和database中有
-	if user, ok := gUserMap[userId]; ok {
+	gUserLock.RLock()
+	user, ok := gUserMap[userId]
+	gUserLock.RUnlock()
+	if ok {
 		return user
 	}
 
 	// redis中有，获取到之后需要保存到本地缓存
 	user, err := loadUserFromRedis(userId)
 	if err == nil {
-		gUserMap[user.Id] = user
+		setUser2Map(user)
 		return user
 	}
 
 	user, err = loadUserFromData(userId)
 	if err == nil {
 		// 保存到本地缓存和redis
-		gUserMap[user.Id] = user
+		setUser2Map(user)
 		setUser2Redis(user, 0)
 	}
 	return user
@@ -0,0 +1,79 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jianshao/poker_counter/src/utils"
+)
+
+const (
+	// 登录凭证有效期，单位秒
+	SESSION_TIMEOUT = 7 * 24 * 3600
+)
+
+// 用户登录时微信返回的会话信息，登录凭证与其绑定
+type Session struct {
+	UserId     int
+	OpenId     string
+	SessionKey string
+}
+
+func buildSessionKey(userId int) string {
+	return fmt.Sprintf("Session:%d", userId)
+}
+
+func loadSession(userId int) (*Session, error) {
+	sessionStr, err := utils.GetString(buildSessionKey(userId))
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal([]byte(sessionStr), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// 保存会话并签发登录凭证，重新登录后之前的凭证失效
+func createSession(userId int, openId, sessionKey string) (string, error) {
+	session := &Session{
+		UserId:     userId,
+		OpenId:     openId,
+		SessionKey: sessionKey,
+	}
+	sessionStr, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	if err := utils.SetString(buildSessionKey(userId), string(sessionStr), SESSION_TIMEOUT); err != nil {
+		return "", err
+	}
+
+	return utils.SignToken(&utils.TokenClaims{
+		UserId: userId,
+		OpenId: openId,
+		Expire: time.Now().Unix() + SESSION_TIMEOUT,
+	}, sessionKey)
+}
+
+// 校验登录凭证，返回凭证对应的用户
+func Authenticate(token string) (int, error) {
+	claims, err := utils.ParseToken(token, func(claims *utils.TokenClaims) (string, error) {
+		session, err := loadSession(claims.UserId)
+		if err != nil {
+			return "", err
+		}
+		if session.OpenId != claims.OpenId {
+			return "", errors.New("openid not match")
+		}
+		return session.SessionKey, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserId, nil
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/jianshao/poker_counter/src/model/records"
+	"github.com/jianshao/poker_counter/src/utils/logs"
 	"github.com/jianshao/poker_counter/src/view"
 )
 
@@ -21,24 +22,49 @@ func UserCheck(openId string) (*PlayerInfo, error) {
 	}, nil
 }
 
-func UserLogin(userId int) *PlayerInfo {
+// 使用微信返回的openid和session_key登录，返回用户信息和登录凭证
+func UserLogin(openId, sessionKey string) (*PlayerInfo, string, error) {
+	user, err := view.GetUserInfoByOpenid(openId)
+	if err != nil {
+		return nil, "", err
+	}
+
 	// 将用户的信息载入到进程中，以备后面使用
-	return loadUser(userId)
+	player := GetUser(user.ID)
+	if player == nil {
+		return nil, "", errors.New("user not exist")
+	}
+
+	token, err := createSession(player.Id, openId, sessionKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return player, token, nil
 }
 
-func UserRegister(name, openId string) (*PlayerInfo, error) {
+func UserRegister(name, openId, sessionKey string) (*PlayerInfo, string, error) {
 	// 在database中插入一条记录
 	user, err := view.CreateOneUser(name, openId)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	player := GetUser(user.ID)
+	if player == nil {
+		return nil, "", errors.New("user not exist")
 	}
 
-	return loadUser(user.ID), nil
+	token, err := createSession(player.Id, openId, sessionKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return player, token, nil
 }
 
 func EntryRoom(roomId, userId int) error {
+	defer lockUser(userId)()
 	// 先检查用户是否存在
-	user := GetUser(userId)
+	user := getUser(userId)
 	if user == nil {
 		return errors.New("user not exist")
 	}
@@ -54,6 +80,7 @@ func EntryRoom(roomId, userId int) error {
 	}
 
 	// 将用户的房间信息更新到本地缓存
+	prevRoomId := user.CurrRoomId
 	user.CurrRoomId = roomId
 	// 如果用户的房间信息不存在，就初始化一下
 	if _, ok := user.Rooms[roomId]; !ok {
@@ -61,14 +88,19 @@ func EntryRoom(roomId, userId int) error {
 			ApplyList: make(map[int]int),
 		}
 	}
-	setUser2Redis(user, 0)
-
-	return nil
+	// 离开之前所在的房间
+	if prevRoomId != 0 {
+		if err := saveUserRoom(user, prevRoomId); err != nil {
+			return err
+		}
+	}
+	return saveUserRoom(user, roomId)
 }
 
 func LeaveRoom(roomId, userId int) error {
+	defer lockUser(userId)()
 	// 先检查用户是否存在
-	user := GetUser(userId)
+	user := getUser(userId)
 	if user == nil {
 		return errors.New("user not exist")
 	}
@@ -87,14 +119,18 @@ func LeaveRoom(roomId, userId int) error {
 		return errors.New("user is playing, quit first")
 	}
 
+	// 授予的管理角色在离开房间后失效，再次进入需要重新授予
+	if room := user.Rooms[roomId]; room.Role == USER_ROLE_BANKER || room.Role == USER_ROLE_COHOST {
+		room.Role = USER_ROLE_PLAYER
+	}
 	user.CurrRoomId = 0
-	setUser2Redis(user, 0)
-	return nil
+	return saveUserRoom(user, roomId)
 }
 
 func JoinGame(roomId, userId int) error {
+	defer lockUser(userId)()
 	// 先检查用户是否存在
-	user := GetUser(userId)
+	user := getUser(userId)
 	if user == nil {
 		return errors.New("user not exist")
 	}
@@ -111,12 +147,33 @@ func JoinGame(roomId, userId int) error {
 	if user.Rooms[user.CurrRoomId].JoinTime == "" {
 		user.Rooms[user.CurrRoomId].JoinTime = time.Now().Format("2006-01-02 15:04:05")
 	}
-	setUser2Redis(user, 0)
-	return nil
+	return saveUserRoom(user, roomId)
+}
+
+// 修改用户在房间内的角色，游戏中的用户不能改为观众
+func SetRoomRole(roomId, userId, role int) error {
+	defer lockUser(userId)()
+	user := getUser(userId)
+	if user == nil {
+		return errors.New("user not exist")
+	}
+
+	room, ok := user.Rooms[roomId]
+	if !ok {
+		return errors.New("user not in this room")
+	}
+
+	if role == USER_ROLE_SPECTATOR && room.Status == USER_STATUS_PLAYING {
+		return errors.New("user is playing, quit first")
+	}
+
+	room.Role = role
+	return saveUserRoom(user, roomId)
 }
 
 func QuitGame(roomId, userId int) error {
-	user := GetUser(userId)
+	defer lockUser(userId)()
+	user := getUser(userId)
 	if user == nil {
 		return errors.New("user not exist")
 	}
@@ -131,17 +188,18 @@ func QuitGame(roomId, userId int) error {
 
 	user.Rooms[user.CurrRoomId].Status = USER_STATUS_QUIT
 	user.Rooms[user.CurrRoomId].ExitTime = time.Now().Format("2006-01-02 15:04:05")
-	setUser2Redis(user, 0)
-	return nil
+	return saveUserRoom(user, roomId)
 }
 
-func addName2Apply(apply *records.ApplyScore) {
-	user := GetUser(apply.UserId)
-	apply.Name = user.Name
+func addName2Apply(apply *records.ApplyScore, user *PlayerInfo) {
+	if user != nil {
+		apply.Name = user.Name
+	}
 }
 
 func ApplyBuyIn(roomId, userId, score, applyType int) (*records.ApplyScore, error) {
-	user := GetUser(userId)
+	defer lockUser(userId)()
+	user := getUser(userId)
 	if user == nil {
 		return nil, errors.New("user not exist")
 	}
@@ -159,38 +217,398 @@ func ApplyBuyIn(roomId, userId, score, applyType int) (*records.ApplyScore, erro
 		return nil, err
 	}
 
+	// 申请列表可以从记录表中重建，只需要更新缓存
 	user.Rooms[user.CurrRoomId].ApplyList[apply.Id] = apply.Id
 	setUser2Redis(user, 0)
 
-	addName2Apply(apply)
+	addName2Apply(apply, user)
 	return apply, nil
 }
 
 func ConfirmBuyIn(applyId, status int) (*records.ApplyScore, error) {
-	apply, err := records.ConfirmBuyIn(applyId, status)
+	apply, score, err := records.ConfirmBuyIn(applyId, status)
 	if err != nil {
 		return nil, err
 	}
+	return updateConfirmedScore(apply, score)
+}
 
-	// 更新用户的分数状态
-	user := GetUser(apply.UserId)
-	// 申请类型：0-申请买入，1-申请结算
-	if apply.ApplyType == 0 {
-		// 确认状态：0-未确认，1-同意，2-拒绝
-		if status == 1 {
-			user.Rooms[apply.RoomId].CurrScore += apply.Score
+// 按自动审批规则通过申请
+func AutoAcceptApply(applyId int) (*records.ApplyScore, error) {
+	apply, score, err := records.AutoAcceptApply(applyId)
+	if err != nil {
+		return nil, err
+	}
+	return updateConfirmedScore(apply, score)
+}
+
+// 审批完成后更新用户的分数状态
+// 审批结果已经提交，缓存中找不到用户或房间时丢弃缓存，下次访问从数据库重建
+func updateConfirmedScore(apply *records.ApplyScore, score *records.PlayerScore) (*records.ApplyScore, error) {
+	defer lockUser(apply.UserId)()
+	user := getUser(apply.UserId)
+	if user == nil {
+		logs.Error(nil, fmt.Sprintf("user %d of confirmed apply %d not exist", apply.UserId, apply.Id))
+		invalidateUser(apply.UserId)
+		return apply, nil
+	}
+	room, ok := user.Rooms[apply.RoomId]
+	if !ok {
+		logs.Error(nil, fmt.Sprintf("user %d of confirmed apply %d not in room %d", apply.UserId, apply.Id, apply.RoomId))
+		invalidateUser(apply.UserId)
+		addName2Apply(apply, user)
+		return apply, nil
+	}
+	if score != nil {
+		// 数据库中的积分已经和审批结果一起更新，只需要同步缓存
+		room.CurrScore = score.CurrScore
+		room.FinalScore = score.FinalScore
+		setUser2Redis(user, 0)
+	} else if apply.Status == records.APPLY_STATUS_ACCEPT {
+		// 没有玩家记录时按申请累加积分，并写入玩家记录
+		// 申请类型：0-申请买入，1-申请结算
+		if apply.ApplyType == records.APPLY_TYPE_BUYIN {
+			room.CurrScore += apply.Score
+		} else {
+			room.FinalScore += apply.Score
 		}
-	} else {
-		if status == 1 {
-			user.Rooms[apply.RoomId].FinalScore += apply.Score
+		if err := saveUserRoom(user, apply.RoomId); err != nil {
+			return nil, err
 		}
 	}
 
+	addName2Apply(apply, user)
+	return apply, nil
+}
+
+// 撤回申请，并从申请列表中移除
+func CancelApply(roomId, userId, applyId int) (*records.ApplyScore, error) {
+	defer lockUser(userId)()
+	user := getUser(userId)
+	if user == nil {
+		return nil, errors.New("user not exist")
+	}
+	room, ok := user.Rooms[roomId]
+	if !ok {
+		return nil, errors.New("user not in this room")
+	}
+
+	apply, err := records.CancelApply(applyId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// 申请列表可以从记录表中重建，只需要更新缓存
+	delete(room.ApplyList, applyId)
 	setUser2Redis(user, 0)
-	addName2Apply(apply)
+
+	addName2Apply(apply, user)
+	return apply, nil
+}
+
+// 结算有争议，等待房主处理，申请列表不变
+func DisputeApply(applyId, userId int, content string) (*records.ApplyScore, error) {
+	apply, err := records.DisputeApply(applyId, userId, content)
+	if err != nil {
+		return nil, err
+	}
+
+	defer lockUser(apply.UserId)()
+	if user := getUser(apply.UserId); user != nil {
+		addName2Apply(apply, user)
+	}
+	return apply, nil
+}
+
+// 房主处理有争议的结算，拒绝代录的结算时玩家恢复为游戏中，由玩家重新提交
+func ResolveDispute(applyId, status, score int) (*records.ApplyScore, error) {
+	apply, playerScore, err := records.ResolveDispute(applyId, status, score)
+	if err != nil {
+		return nil, err
+	}
+	apply, err = updateConfirmedScore(apply, playerScore)
+	if err != nil {
+		return nil, err
+	}
+	if apply.Status == records.APPLY_STATUS_REJECT && apply.CreatedBy != 0 {
+		if err := resumePlaying(apply.RoomId, apply.UserId); err != nil {
+			return nil, err
+		}
+	}
 	return apply, nil
 }
 
+// 代录结算时玩家已经退出游戏，结算被拒绝后恢复为游戏中
+func resumePlaying(roomId, userId int) error {
+	defer lockUser(userId)()
+	user := getUser(userId)
+	if user == nil {
+		return errors.New("user not exist")
+	}
+	room, ok := user.Rooms[roomId]
+	if !ok {
+		return errors.New("user not in this room")
+	}
+	room.Status = USER_STATUS_PLAYING
+	room.ExitTime = ""
+	return saveUserRoom(user, roomId)
+}
+
+// 超时未审批的申请过期，从申请列表中移除
+func ExpireApply(roomId, userId, applyId int) (*records.ApplyScore, error) {
+	defer lockUser(userId)()
+	apply, err := records.ExpireApply(applyId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// 玩家已经不在缓存中时只修改记录
+	user := getUser(userId)
+	if user == nil {
+		return apply, nil
+	}
+	if room, ok := user.Rooms[roomId]; ok {
+		delete(room.ApplyList, applyId)
+		setUser2Redis(user, 0)
+	}
+
+	addName2Apply(apply, user)
+	return apply, nil
+}
+
+// 修改申请的积分，申请列表不变
+func AmendApply(roomId, userId, applyId, score int) (*records.ApplyScore, error) {
+	defer lockUser(userId)()
+	user := getUser(userId)
+	if user == nil {
+		return nil, errors.New("user not exist")
+	}
+	if _, ok := user.Rooms[roomId]; !ok {
+		return nil, errors.New("user not in this room")
+	}
+
+	apply, err := records.AmendApply(applyId, userId, score)
+	if err != nil {
+		return nil, err
+	}
+
+	addName2Apply(apply, user)
+	return apply, nil
+}
+
+// 冲正记录按类型抵消玩家的买入或结算积分
+func ReverseRecord(applyId int) (*records.ApplyScore, error) {
+	reversal, err := records.ReverseRecord(applyId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer lockUser(reversal.UserId)()
+	user := getUser(reversal.UserId)
+	if user == nil {
+		return nil, errors.New("user not exist")
+	}
+	room, ok := user.Rooms[reversal.RoomId]
+	if !ok {
+		return nil, errors.New("user not in this room")
+	}
+
+	if reversal.ApplyType == records.APPLY_TYPE_BUYIN {
+		room.CurrScore += reversal.Score
+	} else {
+		room.FinalScore += reversal.Score
+	}
+	room.ApplyList[reversal.Id] = reversal.Id
+	if err := saveUserRoom(user, reversal.RoomId); err != nil {
+		return nil, err
+	}
+
+	addName2Apply(reversal, user)
+	return reversal, nil
+}
+
+// 玩家是否有待处理或已确认的结算，已被冲正的结算不算
+func hasCashOut(room *UserRoomInfo) bool {
+	count := 0
+	for applyId := range room.ApplyList {
+		apply, err := records.GetApply(applyId)
+		if err != nil || apply.ApplyType != records.APPLY_TYPE_CASHOUT {
+			continue
+		}
+		if apply.RefId != 0 {
+			count -= 1
+		} else if apply.Status == records.APPLY_STATUS_ACCEPT || records.IsPendingCashOut(apply) {
+			count += 1
+		}
+	}
+	return count > 0
+}
+
+// 代玩家录入买入或结算：买入时玩家进入游戏中状态，结算时视为玩家已提交剩余积分并退出游戏
+func AddProxyRecord(roomId, userId, score, applyType, operator int, pending bool) (*records.ApplyScore, error) {
+	defer lockUser(userId)()
+	user := getUser(userId)
+	if user == nil {
+		return nil, errors.New("user not exist")
+	}
+
+	room, ok := user.Rooms[roomId]
+	if !ok {
+		return nil, errors.New("user not in this room")
+	}
+
+	// 代录结算只针对游戏中且没有提交过结算的玩家，避免重复计入结算积分
+	if applyType == records.APPLY_TYPE_CASHOUT {
+		if room.Status != USER_STATUS_PLAYING {
+			return nil, errors.New("user not playing")
+		}
+		if hasCashOut(room) {
+			return nil, errors.New("玩家已经提交过结算")
+		}
+	}
+
+	apply, err := records.AddProxyRecord(roomId, userId, score, applyType, operator, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	if applyType == records.APPLY_TYPE_BUYIN {
+		room.CurrScore += apply.Score
+		room.Status = USER_STATUS_PLAYING
+		if room.JoinTime == "" {
+			room.JoinTime = now
+		}
+	} else {
+		// 等待玩家确认的结算在确认后才计入积分
+		if !pending {
+			room.FinalScore += apply.Score
+		}
+		room.Status = USER_STATUS_QUIT
+		room.ExitTime = now
+	}
+	room.ApplyList[apply.Id] = apply.Id
+	if err := saveUserRoom(user, roomId); err != nil {
+		return nil, err
+	}
+
+	addName2Apply(apply, user)
+	return apply, nil
+}
+
+// 手动调整计入玩家的结算积分
+func AddAdjustment(roomId, userId, score int, reason string) (*records.ApplyScore, error) {
+	defer lockUser(userId)()
+	user := getUser(userId)
+	if user == nil {
+		return nil, errors.New("user not exist")
+	}
+
+	room, ok := user.Rooms[roomId]
+	if !ok {
+		return nil, errors.New("user not in this room")
+	}
+
+	apply, err := records.AddAdjustment(roomId, userId, score, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	room.FinalScore += apply.Score
+	room.ApplyList[apply.Id] = apply.Id
+	if err := saveUserRoom(user, roomId); err != nil {
+		return nil, err
+	}
+
+	addName2Apply(apply, user)
+	return apply, nil
+}
+
+// 平账积分计入玩家的结算积分
+func AddBalance(roomId, userId, score int) (*records.ApplyScore, error) {
+	if !isInRoom(roomId, userId) {
+		return nil, errors.New("user not in this room")
+	}
+
+	apply, err := records.AddBalance(roomId, userId, score)
+	if err != nil {
+		return nil, err
+	}
+	if err := addBalance2User(apply); err != nil {
+		return nil, err
+	}
+	return apply, nil
+}
+
+// 一次给多个玩家写入平账记录，记录在同一个事务中写入，之后再逐个更新玩家的结算积分
+func AddBalances(roomId int, shares map[int]int) ([]records.ApplyScore, error) {
+	for userId := range shares {
+		if !isInRoom(roomId, userId) {
+			return nil, errors.New("user not in this room")
+		}
+	}
+
+	applies, err := records.AddBalances(roomId, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	// 记录已经生效，某个玩家更新失败时继续更新其他玩家
+	var saveErr error
+	for i := range applies {
+		if err := addBalance2User(&applies[i]); err != nil && saveErr == nil {
+			saveErr = err
+		}
+	}
+	return applies, saveErr
+}
+
+func isInRoom(roomId, userId int) bool {
+	defer lockUser(userId)()
+	user := getUser(userId)
+	if user == nil {
+		return false
+	}
+	_, ok := user.Rooms[roomId]
+	return ok
+}
+
+func addBalance2User(apply *records.ApplyScore) error {
+	defer lockUser(apply.UserId)()
+	user := getUser(apply.UserId)
+	if user == nil {
+		return errors.New("user not exist")
+	}
+	room, ok := user.Rooms[apply.RoomId]
+	if !ok {
+		return errors.New("user not in this room")
+	}
+
+	room.FinalScore += apply.Score
+	room.ApplyList[apply.Id] = apply.Id
+	if err := saveUserRoom(user, apply.RoomId); err != nil {
+		return err
+	}
+	addName2Apply(apply, user)
+	return nil
+}
+
+func GetRoomHistory(roomId int) ([]records.ApplyScore, error) {
+	applies, err := records.GetRoomHistory(roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range applies {
+		// 记在房间名下的平账没有对应的用户
+		if applies[i].UserId == 0 {
+			continue
+		}
+		addName2Apply(&applies[i], GetUser(applies[i].UserId))
+	}
+	return applies, nil
+}
+
 func GetAllScoreApplies(roomId int) ([]records.ApplyScore, error) {
 	applies, err := records.GetApplyScoreAll(roomId)
 	if err != nil {
@@ -198,15 +616,46 @@ func GetAllScoreApplies(roomId int) ([]records.ApplyScore, error) {
 	}
 
 	for i, _ := range applies {
-		addName2Apply(&applies[i])
+		addName2Apply(&applies[i], GetUser(applies[i].UserId))
 	}
 	return applies, nil
 }
 
+// 从database重建用户数据，并用记录重放的结果覆盖房间内的积分
+func RestoreRoom(roomId, userId int, replay *records.Replay) error {
+	defer lockUser(userId)()
+	user := reloadUser(userId)
+	if user == nil {
+		return errors.New("user not exist")
+	}
+
+	room, ok := user.Rooms[roomId]
+	if !ok {
+		// 没有房间状态时根据记录推断：已经结算过的视为退出游戏
+		room = &UserRoomInfo{
+			Status:    USER_STATUS_PLAYING,
+			ApplyList: map[int]int{},
+		}
+		if replay != nil && replay.CashedOut {
+			room.Status = USER_STATUS_QUIT
+		}
+		user.Rooms[roomId] = room
+	}
+
+	if replay != nil {
+		room.CurrScore = replay.CurrScore
+		room.FinalScore = replay.FinalScore
+		room.ApplyList = replay.ApplyList
+	}
+	return saveUserRoom(user, roomId)
+}
+
 func ClearUnusedRooms(users, rooms map[int]int) {
 	for userId, _ := range users {
-		user := GetUser(userId)
+		unlock := lockUser(userId)
+		user := getUser(userId)
 		if user == nil {
+			unlock()
 			continue
 		}
 
@@ -219,6 +668,7 @@ func ClearUnusedRooms(users, rooms map[int]int) {
 			}
 		}
 		setUser2Redis(user, 0)
+		unlock()
 	}
 	return
 }
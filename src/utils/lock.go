@@ -0,0 +1,17 @@
+package utils
+
+import "sync"
+
+// 按key加锁，不同key之间互不影响
+// 锁创建后不会释放，key的数量与房间、用户的数量相当，占用的内存可以忽略
+type KeyedMutex struct {
+	locks sync.Map
+}
+
+// 加锁并返回解锁函数，可以直接defer m.Lock(key)()
+func (m *KeyedMutex) Lock(key int) func() {
+	value, _ := m.locks.LoadOrStore(key, &sync.Mutex{})
+	lock := value.(*sync.Mutex)
+	lock.Lock()
+	return lock.Unlock
+}
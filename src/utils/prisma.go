@@ -2,15 +2,20 @@ package utils
 
 import (
 	"log"
+	"sync"
 
 	"github.com/jianshao/poker_counter/prisma/db"
 )
 
 var (
 	gPrisma *db.PrismaClient = nil
+	// 客户端本身可以并发使用，只需要保护初始化和关闭
+	gPrismaLock sync.Mutex
 )
 
 func GetPrismaClient() *db.PrismaClient {
+	gPrismaLock.Lock()
+	defer gPrismaLock.Unlock()
 	if gPrisma == nil {
 		gPrisma = db.NewClient()
 		if err := gPrisma.Prisma.Connect(); err != nil {
@@ -22,6 +27,8 @@ func GetPrismaClient() *db.PrismaClient {
 }
 
 func closePrisma() {
+	gPrismaLock.Lock()
+	defer gPrismaLock.Unlock()
 	if gPrisma != nil {
 		gPrisma.Prisma.Disconnect()
 		gPrisma = nil
@@ -2,39 +2,72 @@ package utils
 
 import (
 	"errors"
+	"sync"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/jianshao/poker_counter/src/config"
 )
 
+const (
+	REDIS_MAX_IDLE     = 16
+	REDIS_IDLE_TIMEOUT = 240 * time.Second
+)
+
+// redis.Conn不能在多个协程间共用，每次操作从连接池中获取连接
 var (
-	gRedisConn redis.Conn = nil
+	gRedisPool *redis.Pool = nil
+	gRedisLock sync.Mutex
 )
 
-func GetRedisConn() redis.Conn {
-	if gRedisConn == nil {
-		conn, err := redis.Dial("tcp", config.REDIS_ADDR)
-		if err == nil {
-			gRedisConn = conn
+func getRedisPool() *redis.Pool {
+	gRedisLock.Lock()
+	defer gRedisLock.Unlock()
+	if gRedisPool == nil {
+		gRedisPool = &redis.Pool{
+			MaxIdle:     REDIS_MAX_IDLE,
+			IdleTimeout: REDIS_IDLE_TIMEOUT,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", config.REDIS_ADDR)
+			},
 		}
 	}
-	return gRedisConn
+	return gRedisPool
+}
+
+// 替换连接池，用于测试时连接到内存实现，之前的连接池会被关闭
+func SetRedisPool(pool *redis.Pool) {
+	gRedisLock.Lock()
+	defer gRedisLock.Unlock()
+	if gRedisPool != nil {
+		gRedisPool.Close()
+	}
+	gRedisPool = pool
+}
+
+// 从连接池中获取连接，使用完需要调用Close归还
+func GetRedisConn() redis.Conn {
+	return getRedisPool().Get()
 }
 
 func closeRedis() {
-	if gRedisConn != nil {
-		gRedisConn.Close()
-		gRedisConn = nil
+	gRedisLock.Lock()
+	defer gRedisLock.Unlock()
+	if gRedisPool != nil {
+		gRedisPool.Close()
+		gRedisPool = nil
 	}
 }
 
 func GetString(key string) (string, error) {
 	conn := GetRedisConn()
+	defer conn.Close()
 	return redis.String(conn.Do("GET", key))
 }
 
 func SetString(key, value string, timeout int) error {
 	conn := GetRedisConn()
+	defer conn.Close()
 	err := errors.New("")
 	if timeout == 0 {
 		_, err = conn.Do("SET", key, value)
@@ -45,24 +78,85 @@ func SetString(key, value string, timeout int) error {
 	return err
 }
 
+// key不存在时才写入，返回是否写入成功
+func SetStringNX(key, value string, timeout int) (bool, error) {
+	conn := GetRedisConn()
+	defer conn.Close()
+	_, err := redis.String(conn.Do("SET", key, value, "EX", timeout, "NX"))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func GetInt(key string) (int, error) {
 	conn := GetRedisConn()
+	defer conn.Close()
 	return redis.Int(conn.Do("GET", key))
 }
 
 func Inc(key string) (int, error) {
 	conn := GetRedisConn()
+	defer conn.Close()
 	return redis.Int(conn.Do("INCR", key))
 }
 
 func SetInt(key string, value int) error {
 	conn := GetRedisConn()
+	defer conn.Close()
 	_, err := conn.Do("SET", key, value)
 	return err
 }
 
+// 返回实际新增的成员数量，可用于判断是否抢占成功
+func SAdd(key string, members ...interface{}) (int, error) {
+	conn := GetRedisConn()
+	defer conn.Close()
+	return redis.Int(conn.Do("SADD", append([]interface{}{key}, members...)...))
+}
+
+func SRem(key string, member interface{}) error {
+	conn := GetRedisConn()
+	defer conn.Close()
+	_, err := conn.Do("SREM", key, member)
+	return err
+}
+
+func Rename(key, newKey string) error {
+	conn := GetRedisConn()
+	defer conn.Close()
+	_, err := conn.Do("RENAME", key, newKey)
+	return err
+}
+
 func Del(key string) error {
 	conn := GetRedisConn()
+	defer conn.Close()
 	_, err := conn.Do("DEL", key)
 	return err
 }
+
+// 删除匹配pattern的所有key，使用SCAN分批遍历，不会长时间阻塞redis，返回删除的数量
+func DelKeys(pattern string) (int, error) {
+	conn := GetRedisConn()
+	defer conn.Close()
+	cursor, deleted := 0, 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
+		if err != nil {
+			return deleted, err
+		}
+		cursor, _ = redis.Int(values[0], nil)
+		keys, _ := redis.Values(values[1], nil)
+		if len(keys) > 0 {
+			count, err := redis.Int(conn.Do("DEL", keys...))
+			if err != nil {
+				return deleted, err
+			}
+			deleted += count
+		}
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
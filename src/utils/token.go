@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// 登录凭证中携带的信息
+type TokenClaims struct {
+	UserId int    `json:"uid"`
+	OpenId string `json:"openid"`
+	Expire int64  `json:"exp"`
+}
+
+var (
+	ErrTokenInvalid = errors.New("token invalid")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+func tokenSecret() ([]byte, error) {
+	secret := os.Getenv("SESSION_SECRET")
+	if secret == "" {
+		return nil, errors.New("SESSION_SECRET not configured")
+	}
+	return []byte(secret), nil
+}
+
+// 签名时混入bindKey，bindKey变化后旧凭证自动失效
+func signPayload(payload, bindKey string) (string, error) {
+	secret, err := tokenSecret()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	mac.Write([]byte(bindKey))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// 生成凭证，格式为 base64(claims).base64(signature)
+func SignToken(claims *TokenClaims, bindKey string) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	signature, err := signPayload(payload, bindKey)
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + signature, nil
+}
+
+// 校验凭证，getBindKey根据凭证中的信息返回签名时使用的bindKey
+func ParseToken(token string, getBindKey func(claims *TokenClaims) (string, error)) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrTokenInvalid
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	bindKey, err := getBindKey(&claims)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	signature, err := signPayload(parts[0], bindKey)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(parts[1])) {
+		return nil, ErrTokenInvalid
+	}
+	if time.Now().Unix() > claims.Expire {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
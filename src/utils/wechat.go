@@ -24,7 +24,7 @@ type QrCodeResponse struct {
 	ErrMsg    string `json:"errmsg"`
 }
 
-var result struct {
+type sessionResponse struct {
 	Openid     string `json:"openid"`
 	SessionKey string `json:"session_key"`
 	ErrCode    int    `json:"errcode"`
@@ -48,11 +48,13 @@ func GetWechatOpenidAndSessionKey(code string) (openid, sessionKey string, err e
 	}
 	defer resp.Body.Close()
 
+	var result sessionResponse
 	err = json.NewDecoder(resp.Body).Decode(&result)
 	if err != nil {
 		return "", "", err
 	}
-	log.Default().Printf("get openid from wechat: %s", result)
+	// session_key用于校验登录凭证，不能输出到日志
+	log.Default().Printf("get openid from wechat: %s", result.Openid)
 
 	if result.ErrCode != 0 {
 		return "", "", fmt.Errorf("error from wechat: %d - %s", result.ErrCode, result.ErrMsg)
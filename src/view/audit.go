@@ -0,0 +1,29 @@
+package view
+
+import (
+	"context"
+
+	"github.com/jianshao/poker_counter/prisma/db"
+	"github.com/jianshao/poker_counter/src/utils"
+)
+
+func (prismaStore) InsertAuditEvent(roomId, actor int, action, targetType string, targetId int, before, after []byte) (*db.AuditEventModel, error) {
+	client := utils.GetPrismaClient()
+	return client.AuditEvent.CreateOne(
+		db.AuditEvent.RoomID.Set(roomId),
+		db.AuditEvent.Action.Set(action),
+		db.AuditEvent.Actor.Set(actor),
+		db.AuditEvent.TargetType.Set(targetType),
+		db.AuditEvent.TargetID.Set(targetId),
+		db.AuditEvent.Before.Set(db.JSON(before)),
+		db.AuditEvent.After.Set(db.JSON(after)),
+	).Exec(context.Background())
+}
+
+// 房间的审计日志，按时间倒序分页
+func (prismaStore) GetAuditEvents(roomId, offset, limit int) ([]db.AuditEventModel, error) {
+	client := utils.GetPrismaClient()
+	return client.AuditEvent.FindMany(
+		db.AuditEvent.RoomID.Equals(roomId),
+	).OrderBy(db.AuditEvent.ID.Order(db.SortOrderDesc)).Skip(offset).Take(limit).Exec(context.Background())
+}
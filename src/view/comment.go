@@ -0,0 +1,25 @@
+package view
+
+import (
+	"context"
+
+	"github.com/jianshao/poker_counter/prisma/db"
+	"github.com/jianshao/poker_counter/src/utils"
+)
+
+func (prismaStore) InsertScoreComment(recordId, userId int, content string) (*db.ScoreCommentModel, error) {
+	client := utils.GetPrismaClient()
+	return client.ScoreComment.CreateOne(
+		db.ScoreComment.RecordID.Set(recordId),
+		db.ScoreComment.UID.Set(userId),
+		db.ScoreComment.Content.Set(content),
+	).Exec(context.Background())
+}
+
+// 记录下的所有留言，按时间顺序
+func (prismaStore) GetScoreComments(recordId int) ([]db.ScoreCommentModel, error) {
+	client := utils.GetPrismaClient()
+	return client.ScoreComment.FindMany(
+		db.ScoreComment.RecordID.Equals(recordId),
+	).OrderBy(db.ScoreComment.ID.Order(db.SortOrderAsc)).Exec(context.Background())
+}
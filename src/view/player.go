@@ -0,0 +1,99 @@
+package view
+
+import (
+	"context"
+
+	"github.com/jianshao/poker_counter/prisma/db"
+	"github.com/jianshao/poker_counter/src/utils"
+)
+
+var (
+	PlayerStatus2int = map[db.PlayerStatus]int{
+		"WATCHING": 0,
+		"PLAYING":  1,
+		"QUIT":     2,
+	}
+	int2PlayerStatus = map[int]db.PlayerStatus{
+		0: "WATCHING",
+		1: "PLAYING",
+		2: "QUIT",
+	}
+	Role2int = map[db.RoomRole]int{
+		"PLAYER":    0,
+		"SPECTATOR": 1,
+		"BANKER":    2,
+		"COHOST":    3,
+	}
+	int2Role = map[int]db.RoomRole{
+		0: "PLAYER",
+		1: "SPECTATOR",
+		2: "BANKER",
+		3: "COHOST",
+	}
+)
+
+type RoomPlayerData struct {
+	RoomId     int
+	UserId     int
+	Status     int
+	Role       int
+	InRoom     bool
+	CurrScore  int
+	FinalScore int
+	JoinTime   string
+	ExitTime   string
+}
+
+// 保存玩家在房间内的状态，不存在时创建
+func (prismaStore) SaveRoomPlayer(data *RoomPlayerData) (*db.RoomPlayerModel, error) {
+	client := utils.GetPrismaClient()
+	return client.RoomPlayer.UpsertOne(
+		db.RoomPlayer.RoomIDUID(
+			db.RoomPlayer.RoomID.Equals(data.RoomId),
+			db.RoomPlayer.UID.Equals(data.UserId),
+		),
+	).Create(
+		db.RoomPlayer.RoomID.Set(data.RoomId),
+		db.RoomPlayer.UID.Set(data.UserId),
+		db.RoomPlayer.Status.Set(int2PlayerStatus[data.Status]),
+		db.RoomPlayer.Role.Set(int2Role[data.Role]),
+		db.RoomPlayer.InRoom.Set(data.InRoom),
+		db.RoomPlayer.CurrScore.Set(data.CurrScore),
+		db.RoomPlayer.FinalScore.Set(data.FinalScore),
+		db.RoomPlayer.JoinTime.Set(data.JoinTime),
+		db.RoomPlayer.ExitTime.Set(data.ExitTime),
+	).Update(
+		db.RoomPlayer.Status.Set(int2PlayerStatus[data.Status]),
+		db.RoomPlayer.Role.Set(int2Role[data.Role]),
+		db.RoomPlayer.InRoom.Set(data.InRoom),
+		db.RoomPlayer.CurrScore.Set(data.CurrScore),
+		db.RoomPlayer.FinalScore.Set(data.FinalScore),
+		db.RoomPlayer.JoinTime.Set(data.JoinTime),
+		db.RoomPlayer.ExitTime.Set(data.ExitTime),
+	).Exec(context.Background())
+}
+
+func (prismaStore) GetRoomPlayer(roomId, userId int) (*db.RoomPlayerModel, error) {
+	client := utils.GetPrismaClient()
+	return client.RoomPlayer.FindUnique(
+		db.RoomPlayer.RoomIDUID(
+			db.RoomPlayer.RoomID.Equals(roomId),
+			db.RoomPlayer.UID.Equals(userId),
+		),
+	).Exec(context.Background())
+}
+
+func (prismaStore) GetRoomPlayers(roomId int) ([]db.RoomPlayerModel, error) {
+	client := utils.GetPrismaClient()
+	return client.RoomPlayer.FindMany(
+		db.RoomPlayer.RoomID.Equals(roomId),
+	).Exec(context.Background())
+}
+
+// 获取用户在所有房间的状态，按更新时间倒序
+func (prismaStore) GetUserRoomPlayers(userId int) ([]db.RoomPlayerModel, error) {
+	client := utils.GetPrismaClient()
+	return client.RoomPlayer.FindMany(
+		db.RoomPlayer.UID.Equals(userId),
+	).OrderBy(db.RoomPlayer.UpdatedTime.Order(db.SortOrderDesc)).Exec(context.Background())
+}
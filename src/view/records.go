@@ -12,23 +12,33 @@ var (
 		0: "APPLY",
 		1: "ACCEPT",
 		2: "REJECT",
+		3: "CANCELLED",
+		4: "EXPIRED",
+		5: "DISPUTED",
 	}
 	Status2int = map[db.ScoreRecordStatus]int{
-		"APPLY":  0,
-		"ACCEPT": 1,
-		"REJECT": 2,
+		"APPLY":     0,
+		"ACCEPT":    1,
+		"REJECT":    2,
+		"CANCELLED": 3,
+		"EXPIRED":   4,
+		"DISPUTED":  5,
 	}
 	int2Type = map[int]db.ScoreRecordType{
 		0: "BUYIN",
 		1: "CASHOUT",
+		2: "BALANCE",
+		3: "ADJUSTMENT",
 	}
 	Type2int = map[db.ScoreRecordType]int{
-		"BUYIN":   0,
-		"CASHOUT": 1,
+		"BUYIN":      0,
+		"CASHOUT":    1,
+		"BALANCE":    2,
+		"ADJUSTMENT": 3,
 	}
 )
 
-func InsertScoreApply(roomId, userId, score, applyType int) (*db.ScoreRecordsModel, error) {
+func (prismaStore) InsertScoreApply(roomId, userId, score, applyType int) (*db.ScoreRecordsModel, error) {
 	client := utils.GetPrismaClient()
 	return client.ScoreRecords.CreateOne(
 		db.ScoreRecords.UID.Set(userId),
@@ -38,16 +48,218 @@ func InsertScoreApply(roomId, userId, score, applyType int) (*db.ScoreRecordsMod
 	).Exec(context.Background())
 }
 
-func UpdateScoreApply(applyId, status int) (*db.ScoreRecordsModel, error) {
+// 插入一条已确认的记录，不需要经过申请流程
+func (prismaStore) InsertScoreRecord(roomId, userId, score, recordType, status int) (*db.ScoreRecordsModel, error) {
 	client := utils.GetPrismaClient()
-	return client.ScoreRecords.FindUnique(
-		db.ScoreRecords.ID.Equals(applyId),
-	).Update(
+	return client.ScoreRecords.CreateOne(
+		db.ScoreRecords.UID.Set(userId),
+		db.ScoreRecords.RoomID.Set(roomId),
+		db.ScoreRecords.Score.Set(score),
+		db.ScoreRecords.Status.Set(int2Status[status]),
+		db.ScoreRecords.Type.Set(int2Type[recordType]),
+	).Exec(context.Background())
+}
+
+// 在同一个事务中插入多条已确认的平账记录，任意一条失败时全部回滚
+func (prismaStore) InsertBalanceRecords(roomId int, shares map[int]int) ([]db.ScoreRecordsModel, error) {
+	client := utils.GetPrismaClient()
+	txs := []db.Transaction{}
+	results := []db.ScoreRecordsUniqueTxResult{}
+	for userId, score := range shares {
+		tx := client.ScoreRecords.CreateOne(
+			db.ScoreRecords.UID.Set(userId),
+			db.ScoreRecords.RoomID.Set(roomId),
+			db.ScoreRecords.Score.Set(score),
+			db.ScoreRecords.Status.Set("ACCEPT"),
+			db.ScoreRecords.Type.Set("BALANCE"),
+		).Tx()
+		txs = append(txs, tx)
+		results = append(results, tx)
+	}
+	if err := client.Prisma.Transaction(txs...).Exec(context.Background()); err != nil {
+		return nil, err
+	}
+
+	records := []db.ScoreRecordsModel{}
+	for _, tx := range results {
+		records = append(records, *tx.Result())
+	}
+	return records, nil
+}
+
+// 审批后的记录，以及同时更新的玩家积分；拒绝或玩家记录不存在时积分为空
+type ConfirmedRecord struct {
+	db.ScoreRecordsModel
+	CurrScore  *int `json:"curr_score"`
+	FinalScore *int `json:"final_score"`
+}
+
+// 同意时在同一条语句中累加玩家的积分：买入计入curr_score，其他计入final_score
+const confirmPlayerScoreSql = `
+, player AS (
+	UPDATE "RoomPlayer" AS p SET
+		"curr_score" = p."curr_score" + CASE WHEN r."type" = 'BUYIN' THEN r."score" ELSE 0 END,
+		"final_score" = p."final_score" + CASE WHEN r."type" = 'BUYIN' THEN 0 ELSE r."score" END,
+		"updated_time" = now()
+	FROM record AS r
+	WHERE r."status" = 'ACCEPT' AND p."room_id" = r."room_id" AND p."uid" = r."uid"
+	RETURNING p."curr_score", p."final_score"
+)
+SELECT record.*, player."curr_score", player."final_score" FROM record LEFT JOIN player ON true`
+
+// 只修改仍处于申请状态的记录
+const confirmScoreApplySql = `
+WITH record AS (
+	UPDATE "ScoreRecords" SET "status" = $2::"ScoreRecordStatus", "auto" = $3, "updated_time" = now()
+	WHERE "id" = $1 AND "status" = 'APPLY'
+	RETURNING *
+)` + confirmPlayerScoreSql
+
+// 只修改有争议的记录，同意时按处理结果修改积分，拒绝时保留有争议的积分
+const resolveScoreDisputeSql = `
+WITH record AS (
+	UPDATE "ScoreRecords" SET "status" = $2::"ScoreRecordStatus",
+		"score" = CASE WHEN $2::"ScoreRecordStatus" = 'ACCEPT' THEN $3 ELSE "score" END, "updated_time" = now()
+	WHERE "id" = $1 AND "status" = 'DISPUTED'
+	RETURNING *
+)` + confirmPlayerScoreSql
+
+// auto为true表示按自动审批规则通过，记录已经不是申请状态时返回db.ErrNotFound
+func (prismaStore) ConfirmScoreApply(applyId, status int, auto bool) (*ConfirmedRecord, error) {
+	return queryConfirmedRecord(confirmScoreApplySql, applyId, string(int2Status[status]), auto)
+}
+
+// 处理结算争议，记录已经不是争议状态时返回db.ErrNotFound
+func (prismaStore) ResolveScoreDispute(applyId, status, score int) (*ConfirmedRecord, error) {
+	return queryConfirmedRecord(resolveScoreDisputeSql, applyId, string(int2Status[status]), score)
+}
+
+func queryConfirmedRecord(sql string, params ...interface{}) (*ConfirmedRecord, error) {
+	client := utils.GetPrismaClient()
+	var records []ConfirmedRecord
+	err := client.Prisma.QueryRaw(sql, params...).Exec(context.Background(), &records)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, db.ErrNotFound
+	}
+	return &records[0], nil
+}
+
+// 代玩家录入一条买入或结算记录，需要玩家确认的结算为申请状态，其他直接生效
+func (prismaStore) InsertProxyRecord(roomId, userId, score, recordType, status, createdBy int) (*db.ScoreRecordsModel, error) {
+	client := utils.GetPrismaClient()
+	return client.ScoreRecords.CreateOne(
+		db.ScoreRecords.UID.Set(userId),
+		db.ScoreRecords.RoomID.Set(roomId),
+		db.ScoreRecords.Score.Set(score),
 		db.ScoreRecords.Status.Set(int2Status[status]),
+		db.ScoreRecords.Type.Set(int2Type[recordType]),
+		db.ScoreRecords.CreatedBy.Set(createdBy),
 	).Exec(context.Background())
 }
 
-func GetScoreRecords(roomId, status int) ([]db.ScoreRecordsModel, error) {
+// 插入一条直接生效的手动调整记录
+func (prismaStore) InsertAdjustmentRecord(roomId, userId, score int, reason string) (*db.ScoreRecordsModel, error) {
+	client := utils.GetPrismaClient()
+	return client.ScoreRecords.CreateOne(
+		db.ScoreRecords.UID.Set(userId),
+		db.ScoreRecords.RoomID.Set(roomId),
+		db.ScoreRecords.Score.Set(score),
+		db.ScoreRecords.Status.Set("ACCEPT"),
+		db.ScoreRecords.Type.Set("ADJUSTMENT"),
+		db.ScoreRecords.Reason.Set(reason),
+	).Exec(context.Background())
+}
+
+// 插入冲正记录：与原记录类型相同、积分相反且直接生效，原记录已被冲正时返回唯一约束错误
+func (prismaStore) InsertReversalRecord(roomId, userId, score, recordType, refId int) (*db.ScoreRecordsModel, error) {
+	client := utils.GetPrismaClient()
+	return client.ScoreRecords.CreateOne(
+		db.ScoreRecords.UID.Set(userId),
+		db.ScoreRecords.RoomID.Set(roomId),
+		db.ScoreRecords.Score.Set(-score),
+		db.ScoreRecords.Status.Set("ACCEPT"),
+		db.ScoreRecords.Type.Set(int2Type[recordType]),
+		db.ScoreRecords.RefID.Set(refId),
+	).Exec(context.Background())
+}
+
+// 只修改用户自己仍处于申请状态的记录，记录已被处理时返回db.ErrNotFound
+func updateUserScoreApply(applyId, userId int, params ...db.ScoreRecordsSetParam) (*db.ScoreRecordsModel, error) {
+	client := utils.GetPrismaClient()
+	result, err := client.ScoreRecords.FindMany(
+		db.ScoreRecords.ID.Equals(applyId),
+		db.ScoreRecords.UID.Equals(userId),
+		db.ScoreRecords.Status.Equals("APPLY"),
+	).Update(params...).Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if result.Count == 0 {
+		return nil, db.ErrNotFound
+	}
+	return GetScoreRecordById(applyId)
+}
+
+// 撤回申请
+func (prismaStore) CancelScoreApply(applyId, userId int) (*db.ScoreRecordsModel, error) {
+	return updateUserScoreApply(applyId, userId, db.ScoreRecords.Status.Set("CANCELLED"))
+}
+
+// 未关闭房间中超过房间超时时间仍未审批的申请，代录的结算等待玩家确认，不会过期
+const expiredScoreAppliesSql = `
+SELECT s.* FROM "ScoreRecords" s JOIN "Room" r ON s."room_id" = r."id"
+WHERE s."status" = 'APPLY' AND r."status" = 'OPEN' AND r."apply_timeout" > 0
+	AND NOT (s."type" = 'CASHOUT' AND s."created_by" <> 0)
+	AND s."created_time" < now() - make_interval(secs => r."apply_timeout")
+ORDER BY s."room_id", s."id"`
+
+func (prismaStore) GetExpiredScoreApplies() ([]db.ScoreRecordsModel, error) {
+	client := utils.GetPrismaClient()
+	var records []db.ScoreRecordsModel
+	err := client.Prisma.QueryRaw(expiredScoreAppliesSql).Exec(context.Background(), &records)
+	return records, err
+}
+
+// 提出争议和争议留言在同一条语句中写入，留言为空时不写留言
+const disputeScoreApplySql = `
+WITH record AS (
+	UPDATE "ScoreRecords" SET "status" = 'DISPUTED', "updated_time" = now()
+	WHERE "id" = $1 AND "status" = 'APPLY'
+	RETURNING *
+), comment AS (
+	INSERT INTO "ScoreComment" ("record_id", "uid", "content")
+	SELECT "id", $2, $3 FROM record WHERE $3 <> ''
+)
+SELECT * FROM record`
+
+// 结算有争议，userId为提出争议的玩家，记录已被处理时返回db.ErrNotFound
+func (prismaStore) DisputeScoreApply(applyId, userId int, content string) (*db.ScoreRecordsModel, error) {
+	client := utils.GetPrismaClient()
+	var records []db.ScoreRecordsModel
+	err := client.Prisma.QueryRaw(disputeScoreApplySql, applyId, userId, content).Exec(context.Background(), &records)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, db.ErrNotFound
+	}
+	return &records[0], nil
+}
+
+// 申请过期，记录已被处理时返回db.ErrNotFound
+func (prismaStore) ExpireScoreApply(applyId, userId int) (*db.ScoreRecordsModel, error) {
+	return updateUserScoreApply(applyId, userId, db.ScoreRecords.Status.Set("EXPIRED"))
+}
+
+// 修改申请的积分
+func (prismaStore) AmendScoreApply(applyId, userId, score int) (*db.ScoreRecordsModel, error) {
+	return updateUserScoreApply(applyId, userId, db.ScoreRecords.Score.Set(score))
+}
+
+func (prismaStore) GetScoreRecords(roomId, status int) ([]db.ScoreRecordsModel, error) {
 	client := utils.GetPrismaClient()
 	return client.ScoreRecords.FindMany(
 		db.ScoreRecords.Status.Equals(int2Status[status]),
@@ -55,9 +267,34 @@ func GetScoreRecords(roomId, status int) ([]db.ScoreRecordsModel, error) {
 	).OrderBy(db.ScoreRecords.UpdatedTime.Order(db.SortOrderDesc)).Exec(context.Background())
 }
 
-func GetScoreRecordById(id int) (*db.ScoreRecordsModel, error) {
+func (prismaStore) GetScoreRecordsByType(roomId, recordType int) ([]db.ScoreRecordsModel, error) {
+	client := utils.GetPrismaClient()
+	return client.ScoreRecords.FindMany(
+		db.ScoreRecords.Type.Equals(int2Type[recordType]),
+		db.ScoreRecords.RoomID.Equals(roomId),
+	).OrderBy(db.ScoreRecords.UpdatedTime.Order(db.SortOrderDesc)).Exec(context.Background())
+}
+
+func (prismaStore) GetScoreRecordById(id int) (*db.ScoreRecordsModel, error) {
 	client := utils.GetPrismaClient()
 	return client.ScoreRecords.FindUnique(
 		db.ScoreRecords.ID.Equals(id),
 	).Exec(context.Background())
 }
+
+// 房间内的所有记录，按创建时间顺序
+func (prismaStore) GetRoomScoreRecords(roomId int) ([]db.ScoreRecordsModel, error) {
+	client := utils.GetPrismaClient()
+	return client.ScoreRecords.FindMany(
+		db.ScoreRecords.RoomID.Equals(roomId),
+	).OrderBy(db.ScoreRecords.CreatedTime.Order(db.SortOrderAsc)).Exec(context.Background())
+}
+
+// 用户在房间内的所有记录
+func (prismaStore) GetUserScoreRecords(roomId, userId int) ([]db.ScoreRecordsModel, error) {
+	client := utils.GetPrismaClient()
+	return client.ScoreRecords.FindMany(
+		db.ScoreRecords.RoomID.Equals(roomId),
+		db.ScoreRecords.UID.Equals(userId),
+	).OrderBy(db.ScoreRecords.CreatedTime.Order(db.SortOrderAsc)).Exec(context.Background())
+}
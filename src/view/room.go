@@ -9,6 +9,7 @@ import (
 
 	"github.com/jianshao/poker_counter/prisma/db"
 	"github.com/jianshao/poker_counter/src/utils"
+	"github.com/shopspring/decimal"
 )
 
 var (
@@ -20,17 +21,29 @@ var (
 		0: "OPEN",
 		1: "CLOSED",
 	}
+	Rounding2int = map[db.RoundingMode]int{
+		"HALF_UP":   0,
+		"HALF_EVEN": 1,
+		"DOWN":      2,
+		"UP":        3,
+	}
+	int2Rounding = map[int]db.RoundingMode{
+		0: "HALF_UP",
+		1: "HALF_EVEN",
+		2: "DOWN",
+		3: "UP",
+	}
 )
 
 // 往数据库中创建一个房间
-func CreateOneRoom(roomId, owner int) (*db.RoomModel, error) {
-	if roomId == 0 || owner == 0 {
+func (prismaStore) CreateOneRoom(code, owner int) (*db.RoomModel, error) {
+	if code == 0 || owner == 0 {
 		return nil, errors.New("params error")
 	}
 	client := utils.GetPrismaClient()
 
 	room, err := client.Room.CreateOne(
-		db.Room.RoomID.Set(roomId),
+		db.Room.Code.Set(code),
 		db.Room.Owner.Set(owner),
 	).Exec(context.Background())
 	if err != nil {
@@ -40,15 +53,23 @@ func CreateOneRoom(roomId, owner int) (*db.RoomModel, error) {
 	return room, nil
 }
 
-func GetRoomByRoomId(roomId, status int) (*db.RoomModel, error) {
+func (prismaStore) GetRoomById(roomId int) (*db.RoomModel, error) {
+	client := utils.GetPrismaClient()
+	return client.Room.FindUnique(
+		db.Room.ID.Equals(roomId),
+	).Exec(context.Background())
+}
+
+// 房间号只在未关闭的房间中唯一
+func (prismaStore) GetOpenRoomByCode(code int) (*db.RoomModel, error) {
 	client := utils.GetPrismaClient()
 	return client.Room.FindFirst(
-		db.Room.RoomID.Equals(roomId),
-		db.Room.Status.Equals(int2RoomStatus[status]),
+		db.Room.Code.Equals(code),
+		db.Room.Status.Equals("OPEN"),
 	).Exec(context.Background())
 }
 
-func GetOpenRoom(owner int) (*db.RoomModel, error) {
+func (prismaStore) GetOpenRoom(owner int) (*db.RoomModel, error) {
 	client := utils.GetPrismaClient()
 	return client.Room.FindFirst(
 		db.Room.Owner.Equals(owner),
@@ -56,26 +77,104 @@ func GetOpenRoom(owner int) (*db.RoomModel, error) {
 	).Exec(context.Background())
 }
 
-func CloseRoom(roomId, owner int) error {
+func (prismaStore) CloseRoom(roomId, owner int) error {
 	client := utils.GetPrismaClient()
 	client.Room.FindMany(
 		db.Room.Owner.Equals(owner),
 		db.Room.Status.Equals("OPEN"),
-		db.Room.RoomID.Equals(roomId),
+		db.Room.ID.Equals(roomId),
 	).Update(
 		db.Room.Status.Set("CLOSED"),
 	).Exec(context.Background())
 	return nil
 }
 
-func GetAllOpenRooms() ([]db.RoomModel, error) {
+func (prismaStore) UpdateRoomRate(roomId, owner, chips int, money decimal.Decimal, currency string, scale, rounding int) error {
+	client := utils.GetPrismaClient()
+	_, err := client.Room.FindMany(
+		db.Room.Owner.Equals(owner),
+		db.Room.Status.Equals("OPEN"),
+		db.Room.ID.Equals(roomId),
+	).Update(
+		db.Room.Chips.Set(chips),
+		db.Room.Money.Set(money),
+		db.Room.Currency.Set(currency),
+		db.Room.MoneyScale.Set(scale),
+		db.Room.Rounding.Set(int2Rounding[rounding]),
+	).Exec(context.Background())
+	return err
+}
+
+// 保存自动审批规则，规则以json格式存储
+func (prismaStore) UpdateRoomAutoApprove(roomId, owner int, rule []byte) error {
+	client := utils.GetPrismaClient()
+	_, err := client.Room.FindMany(
+		db.Room.Owner.Equals(owner),
+		db.Room.Status.Equals("OPEN"),
+		db.Room.ID.Equals(roomId),
+	).Update(
+		db.Room.AutoApprove.Set(db.JSON(rule)),
+	).Exec(context.Background())
+	return err
+}
+
+// 保存买入限制
+func (prismaStore) UpdateRoomBuyInPolicy(roomId, owner, minBuyIn, maxBuyIn, maxTotalBuyIn, maxRebuys, rebuyCooldown int) error {
+	client := utils.GetPrismaClient()
+	_, err := client.Room.FindMany(
+		db.Room.Owner.Equals(owner),
+		db.Room.Status.Equals("OPEN"),
+		db.Room.ID.Equals(roomId),
+	).Update(
+		db.Room.MinBuyIn.Set(minBuyIn),
+		db.Room.MaxBuyIn.Set(maxBuyIn),
+		db.Room.MaxTotalBuyIn.Set(maxTotalBuyIn),
+		db.Room.MaxRebuys.Set(maxRebuys),
+		db.Room.RebuyCooldown.Set(rebuyCooldown),
+	).Exec(context.Background())
+	return err
+}
+
+func (prismaStore) UpdateRoomApplyTimeout(roomId, owner, timeout int) error {
+	client := utils.GetPrismaClient()
+	_, err := client.Room.FindMany(
+		db.Room.Owner.Equals(owner),
+		db.Room.Status.Equals("OPEN"),
+		db.Room.ID.Equals(roomId),
+	).Update(
+		db.Room.ApplyTimeout.Set(timeout),
+	).Exec(context.Background())
+	return err
+}
+
+func (prismaStore) UpdateRoomTwoSidedCashOut(roomId, owner int, enabled bool) error {
+	client := utils.GetPrismaClient()
+	_, err := client.Room.FindMany(
+		db.Room.Owner.Equals(owner),
+		db.Room.Status.Equals("OPEN"),
+		db.Room.ID.Equals(roomId),
+	).Update(
+		db.Room.TwoSidedCashout.Set(enabled),
+	).Exec(context.Background())
+	return err
+}
+
+func (prismaStore) GetAllOpenRooms() ([]db.RoomModel, error) {
+	client := utils.GetPrismaClient()
+	return client.Room.FindMany(
+		db.Room.Status.Equals("OPEN"),
+	).Exec(context.Background())
+}
+
+func (prismaStore) GetOpenRoomsByIds(roomIds []int) ([]db.RoomModel, error) {
 	client := utils.GetPrismaClient()
 	return client.Room.FindMany(
 		db.Room.Status.Equals("OPEN"),
+		db.Room.ID.In(roomIds),
 	).Exec(context.Background())
 }
 
-func GetOpenRoomsBefore(tt time.Time) ([]db.RoomModel, error) {
+func (prismaStore) GetOpenRoomsBefore(tt time.Time) ([]db.RoomModel, error) {
 	client := utils.GetPrismaClient()
 	return client.Room.FindMany(
 		db.Room.Status.Equals("OPEN"),
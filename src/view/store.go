@@ -0,0 +1,238 @@
+package view
+
+import (
+	"time"
+
+	"github.com/jianshao/poker_counter/prisma/db"
+	"github.com/shopspring/decimal"
+)
+
+// database的所有读写，按表分组。默认使用prisma，测试时可以通过SetStore替换为内存实现。
+type Store interface {
+	GetUserInfoByOpenid(openid string) (*db.UserModel, error)
+	GetUserById(userId int) (*db.UserModel, error)
+	CreateOneUser(name, openId string) (*db.UserModel, error)
+
+	CreateOneRoom(code, owner int) (*db.RoomModel, error)
+	GetRoomById(roomId int) (*db.RoomModel, error)
+	GetOpenRoomByCode(code int) (*db.RoomModel, error)
+	GetOpenRoom(owner int) (*db.RoomModel, error)
+	CloseRoom(roomId, owner int) error
+	UpdateRoomRate(roomId, owner, chips int, money decimal.Decimal, currency string, scale, rounding int) error
+	UpdateRoomAutoApprove(roomId, owner int, rule []byte) error
+	UpdateRoomBuyInPolicy(roomId, owner, minBuyIn, maxBuyIn, maxTotalBuyIn, maxRebuys, rebuyCooldown int) error
+	UpdateRoomApplyTimeout(roomId, owner, timeout int) error
+	UpdateRoomTwoSidedCashOut(roomId, owner int, enabled bool) error
+	GetAllOpenRooms() ([]db.RoomModel, error)
+	GetOpenRoomsByIds(roomIds []int) ([]db.RoomModel, error)
+	GetOpenRoomsBefore(tt time.Time) ([]db.RoomModel, error)
+
+	SaveRoomPlayer(data *RoomPlayerData) (*db.RoomPlayerModel, error)
+	GetRoomPlayer(roomId, userId int) (*db.RoomPlayerModel, error)
+	GetRoomPlayers(roomId int) ([]db.RoomPlayerModel, error)
+	GetUserRoomPlayers(userId int) ([]db.RoomPlayerModel, error)
+
+	InsertScoreApply(roomId, userId, score, applyType int) (*db.ScoreRecordsModel, error)
+	InsertScoreRecord(roomId, userId, score, recordType, status int) (*db.ScoreRecordsModel, error)
+	InsertBalanceRecords(roomId int, shares map[int]int) ([]db.ScoreRecordsModel, error)
+	ConfirmScoreApply(applyId, status int, auto bool) (*ConfirmedRecord, error)
+	ResolveScoreDispute(applyId, status, score int) (*ConfirmedRecord, error)
+	InsertProxyRecord(roomId, userId, score, recordType, status, createdBy int) (*db.ScoreRecordsModel, error)
+	InsertAdjustmentRecord(roomId, userId, score int, reason string) (*db.ScoreRecordsModel, error)
+	InsertReversalRecord(roomId, userId, score, recordType, refId int) (*db.ScoreRecordsModel, error)
+	CancelScoreApply(applyId, userId int) (*db.ScoreRecordsModel, error)
+	GetExpiredScoreApplies() ([]db.ScoreRecordsModel, error)
+	DisputeScoreApply(applyId, userId int, content string) (*db.ScoreRecordsModel, error)
+	ExpireScoreApply(applyId, userId int) (*db.ScoreRecordsModel, error)
+	AmendScoreApply(applyId, userId, score int) (*db.ScoreRecordsModel, error)
+	GetScoreRecords(roomId, status int) ([]db.ScoreRecordsModel, error)
+	GetScoreRecordsByType(roomId, recordType int) ([]db.ScoreRecordsModel, error)
+	GetScoreRecordById(id int) (*db.ScoreRecordsModel, error)
+	GetRoomScoreRecords(roomId int) ([]db.ScoreRecordsModel, error)
+	GetUserScoreRecords(roomId, userId int) ([]db.ScoreRecordsModel, error)
+
+	InsertScoreComment(recordId, userId int, content string) (*db.ScoreCommentModel, error)
+	GetScoreComments(recordId int) ([]db.ScoreCommentModel, error)
+
+	InsertAuditEvent(roomId, actor int, action, targetType string, targetId int, before, after []byte) (*db.AuditEventModel, error)
+	GetAuditEvents(roomId, offset, limit int) ([]db.AuditEventModel, error)
+}
+
+type prismaStore struct{}
+
+var gStore Store = prismaStore{}
+
+// 替换存储实现并返回之前的实现，需要在处理请求之前调用
+func SetStore(store Store) Store {
+	prev := gStore
+	gStore = store
+	return prev
+}
+
+func GetUserInfoByOpenid(openid string) (*db.UserModel, error) {
+	return gStore.GetUserInfoByOpenid(openid)
+}
+
+func GetUserById(userId int) (*db.UserModel, error) {
+	return gStore.GetUserById(userId)
+}
+
+func CreateOneUser(name, openId string) (*db.UserModel, error) {
+	return gStore.CreateOneUser(name, openId)
+}
+
+func CreateOneRoom(code, owner int) (*db.RoomModel, error) {
+	return gStore.CreateOneRoom(code, owner)
+}
+
+func GetRoomById(roomId int) (*db.RoomModel, error) {
+	return gStore.GetRoomById(roomId)
+}
+
+func GetOpenRoomByCode(code int) (*db.RoomModel, error) {
+	return gStore.GetOpenRoomByCode(code)
+}
+
+func GetOpenRoom(owner int) (*db.RoomModel, error) {
+	return gStore.GetOpenRoom(owner)
+}
+
+func CloseRoom(roomId, owner int) error {
+	return gStore.CloseRoom(roomId, owner)
+}
+
+func UpdateRoomRate(roomId, owner, chips int, money decimal.Decimal, currency string, scale, rounding int) error {
+	return gStore.UpdateRoomRate(roomId, owner, chips, money, currency, scale, rounding)
+}
+
+func UpdateRoomAutoApprove(roomId, owner int, rule []byte) error {
+	return gStore.UpdateRoomAutoApprove(roomId, owner, rule)
+}
+
+func UpdateRoomBuyInPolicy(roomId, owner, minBuyIn, maxBuyIn, maxTotalBuyIn, maxRebuys, rebuyCooldown int) error {
+	return gStore.UpdateRoomBuyInPolicy(roomId, owner, minBuyIn, maxBuyIn, maxTotalBuyIn, maxRebuys, rebuyCooldown)
+}
+
+func UpdateRoomApplyTimeout(roomId, owner, timeout int) error {
+	return gStore.UpdateRoomApplyTimeout(roomId, owner, timeout)
+}
+
+func UpdateRoomTwoSidedCashOut(roomId, owner int, enabled bool) error {
+	return gStore.UpdateRoomTwoSidedCashOut(roomId, owner, enabled)
+}
+
+func GetAllOpenRooms() ([]db.RoomModel, error) {
+	return gStore.GetAllOpenRooms()
+}
+
+func GetOpenRoomsByIds(roomIds []int) ([]db.RoomModel, error) {
+	return gStore.GetOpenRoomsByIds(roomIds)
+}
+
+func GetOpenRoomsBefore(tt time.Time) ([]db.RoomModel, error) {
+	return gStore.GetOpenRoomsBefore(tt)
+}
+
+func SaveRoomPlayer(data *RoomPlayerData) (*db.RoomPlayerModel, error) {
+	return gStore.SaveRoomPlayer(data)
+}
+
+func GetRoomPlayer(roomId, userId int) (*db.RoomPlayerModel, error) {
+	return gStore.GetRoomPlayer(roomId, userId)
+}
+
+func GetRoomPlayers(roomId int) ([]db.RoomPlayerModel, error) {
+	return gStore.GetRoomPlayers(roomId)
+}
+
+func GetUserRoomPlayers(userId int) ([]db.RoomPlayerModel, error) {
+	return gStore.GetUserRoomPlayers(userId)
+}
+
+func InsertScoreApply(roomId, userId, score, applyType int) (*db.ScoreRecordsModel, error) {
+	return gStore.InsertScoreApply(roomId, userId, score, applyType)
+}
+
+func InsertScoreRecord(roomId, userId, score, recordType, status int) (*db.ScoreRecordsModel, error) {
+	return gStore.InsertScoreRecord(roomId, userId, score, recordType, status)
+}
+
+func InsertBalanceRecords(roomId int, shares map[int]int) ([]db.ScoreRecordsModel, error) {
+	return gStore.InsertBalanceRecords(roomId, shares)
+}
+
+func ConfirmScoreApply(applyId, status int, auto bool) (*ConfirmedRecord, error) {
+	return gStore.ConfirmScoreApply(applyId, status, auto)
+}
+
+func ResolveScoreDispute(applyId, status, score int) (*ConfirmedRecord, error) {
+	return gStore.ResolveScoreDispute(applyId, status, score)
+}
+
+func InsertProxyRecord(roomId, userId, score, recordType, status, createdBy int) (*db.ScoreRecordsModel, error) {
+	return gStore.InsertProxyRecord(roomId, userId, score, recordType, status, createdBy)
+}
+
+func InsertAdjustmentRecord(roomId, userId, score int, reason string) (*db.ScoreRecordsModel, error) {
+	return gStore.InsertAdjustmentRecord(roomId, userId, score, reason)
+}
+
+func InsertReversalRecord(roomId, userId, score, recordType, refId int) (*db.ScoreRecordsModel, error) {
+	return gStore.InsertReversalRecord(roomId, userId, score, recordType, refId)
+}
+
+func CancelScoreApply(applyId, userId int) (*db.ScoreRecordsModel, error) {
+	return gStore.CancelScoreApply(applyId, userId)
+}
+
+func GetExpiredScoreApplies() ([]db.ScoreRecordsModel, error) {
+	return gStore.GetExpiredScoreApplies()
+}
+
+func DisputeScoreApply(applyId, userId int, content string) (*db.ScoreRecordsModel, error) {
+	return gStore.DisputeScoreApply(applyId, userId, content)
+}
+
+func ExpireScoreApply(applyId, userId int) (*db.ScoreRecordsModel, error) {
+	return gStore.ExpireScoreApply(applyId, userId)
+}
+
+func AmendScoreApply(applyId, userId, score int) (*db.ScoreRecordsModel, error) {
+	return gStore.AmendScoreApply(applyId, userId, score)
+}
+
+func GetScoreRecords(roomId, status int) ([]db.ScoreRecordsModel, error) {
+	return gStore.GetScoreRecords(roomId, status)
+}
+
+func GetScoreRecordsByType(roomId, recordType int) ([]db.ScoreRecordsModel, error) {
+	return gStore.GetScoreRecordsByType(roomId, recordType)
+}
+
+func GetScoreRecordById(id int) (*db.ScoreRecordsModel, error) {
+	return gStore.GetScoreRecordById(id)
+}
+
+func GetRoomScoreRecords(roomId int) ([]db.ScoreRecordsModel, error) {
+	return gStore.GetRoomScoreRecords(roomId)
+}
+
+func GetUserScoreRecords(roomId, userId int) ([]db.ScoreRecordsModel, error) {
+	return gStore.GetUserScoreRecords(roomId, userId)
+}
+
+func InsertScoreComment(recordId, userId int, content string) (*db.ScoreCommentModel, error) {
+	return gStore.InsertScoreComment(recordId, userId, content)
+}
+
+func GetScoreComments(recordId int) ([]db.ScoreCommentModel, error) {
+	return gStore.GetScoreComments(recordId)
+}
+
+func InsertAuditEvent(roomId, actor int, action, targetType string, targetId int, before, after []byte) (*db.AuditEventModel, error) {
+	return gStore.InsertAuditEvent(roomId, actor, action, targetType, targetId, before, after)
+}
+
+func GetAuditEvents(roomId, offset, limit int) ([]db.AuditEventModel, error) {
+	return gStore.GetAuditEvents(roomId, offset, limit)
+}
@@ -7,17 +7,17 @@ import (
 	"github.com/jianshao/poker_counter/src/utils"
 )
 
-func GetUserInfoByOpenid(openid string) (*db.UserModel, error) {
+func (prismaStore) GetUserInfoByOpenid(openid string) (*db.UserModel, error) {
 	client := utils.GetPrismaClient()
 	return client.User.FindUnique(db.User.Openid.Equals(openid)).Exec(context.Background())
 }
 
-func GetUserById(userId int) (*db.UserModel, error) {
+func (prismaStore) GetUserById(userId int) (*db.UserModel, error) {
 	client := utils.GetPrismaClient()
 	return client.User.FindUnique(db.User.ID.Equals(userId)).Exec(context.Background())
 }
 
-func CreateOneUser(name, openId string) (*db.UserModel, error) {
+func (prismaStore) CreateOneUser(name, openId string) (*db.UserModel, error) {
 	client := utils.GetPrismaClient()
 	return client.User.CreateOne(
 		db.User.Openid.Set(openId),